@@ -17,20 +17,95 @@ import (
 )
 
 type Metainfo struct {
-	Announce     string    `bencode:"announce"`
-	Comment      string    `bencode:"comment,ommitempty"`
-	CreationDate time.Time `bencode:"creation date,ommitempty"`
-	HTTPSeeds    []string  `bencode:"httpseeds,ommitempty"`
-	Info         InfoDict  `bencode:"info"`
+	Announce     string     `bencode:"announce"`
+	AnnounceList [][]string `bencode:"announce-list,ommitempty"` // BEP 12 tiers of trackers
+	Comment      string     `bencode:"comment,ommitempty"`
+	CreatedBy    string     `bencode:"created by,ommitempty"`
+	CreationDate time.Time  `bencode:"creation date,ommitempty"`
+	HTTPSeeds    []string   `bencode:"httpseeds,ommitempty"`
+	URLList      []string   `bencode:"url-list,ommitempty"` // BEP 19 web seeds
+	Info         InfoDict   `bencode:"info"`
+
+	// PieceLayers maps the pieces root of each BEP 52 file larger than a piece to the concatenated SHA-256 hashes
+	// of its pieces.
+	PieceLayers map[string][]byte `bencode:"piece layers,ommitempty"`
 }
 
 type InfoDict struct {
 	Name        string `bencode:"name"`
 	Private     bool   `bencode:"private,ommitempty"`
 	PieceLength int64  `bencode:"piece length"`
-	RawPieces   []byte `bencode:"pieces"`
+	RawPieces   []byte `bencode:"pieces,ommitempty"` // absent from BEP 52 torrents which are not hybrids
 	Length      int64  `bencode:"length,ommitempty"`
 	Files       []File `bencode:"files,ommitempty"`
+	Source      string `bencode:"source,ommitempty"`
+
+	MetaVersion int      `bencode:"meta version,ommitempty"` // 2 for BEP 52 torrents
+	FileTree    FileTree `bencode:"file tree,ommitempty"`
+}
+
+// FileTree is a BEP 52 file tree. It maps the name of each file or directory to a FileTree, which for a directory
+// holds its contents, and for a file holds its V2File under the empty name. When decoded, the values are
+// map[string]interface{} instead.
+type FileTree map[string]interface{}
+
+// Files returns the files in t, ordered by path, with their attributes, lengths and symlink paths. It accepts
+// decoded file trees as well as those built from FileTrees and V2Files.
+func (t FileTree) Files() []File {
+	var files []File
+	t.files(nil, &files)
+	return files
+}
+
+func (t FileTree) files(path []string, files *[]File) {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "" {
+			f := t[name]
+			if d, ok := f.(map[string]interface{}); ok {
+				f = v2FileFromDict(d)
+			}
+			if v, ok := f.(V2File); ok {
+				*files = append(*files, File{Attr: v.Attr, Length: v.Length, Path: path, SymlinkPath: v.SymlinkPath})
+			}
+			continue
+		}
+		p := append(path[:len(path):len(path)], name)
+		switch child := t[name].(type) {
+		case FileTree:
+			child.files(p, files)
+		case map[string]interface{}:
+			FileTree(child).files(p, files)
+		}
+	}
+}
+
+func v2FileFromDict(d map[string]interface{}) V2File {
+	var v V2File
+	v.Attr, _ = d["attr"].(string)
+	v.Length, _ = d["length"].(int64)
+	if root, ok := d["pieces root"].(string); ok {
+		v.PiecesRoot = []byte(root)
+	}
+	if path, ok := d["symlink path"].([]interface{}); ok {
+		for _, p := range path {
+			s, _ := p.(string)
+			v.SymlinkPath = append(v.SymlinkPath, s)
+		}
+	}
+	return v
+}
+
+// V2File describes a file in a FileTree.
+type V2File struct {
+	Attr        string   `bencode:"attr,ommitempty"`
+	Length      int64    `bencode:"length"`
+	PiecesRoot  []byte   `bencode:"pieces root,ommitempty"` // absent for empty files
+	SymlinkPath []string `bencode:"symlink path,ommitempty"`
 }
 
 func (i InfoDict) Pieces() [][]byte {
@@ -45,6 +120,81 @@ func (i InfoDict) NumPieces() int {
 	return len(i.RawPieces) / 20
 }
 
+// TotalLength returns the combined length of all the files in the torrent.
+func (i InfoDict) TotalLength() int64 {
+	if i.Length > 0 {
+		return i.Length
+	}
+	var n int64
+	for _, f := range i.Files {
+		n += f.Length
+	}
+	return n
+}
+
+// PieceBounds returns the offset and length of the nth piece within the concatenation of the files in the torrent.
+// All pieces are PieceLength long, except the last which may be shorter.
+func (i InfoDict) PieceBounds(n int) (off, length int64) {
+	off = int64(n) * i.PieceLength
+	length = i.PieceLength
+	if total := i.TotalLength(); off+length > total {
+		length = total - off
+	}
+	return off, length
+}
+
+// A Segment is the part of a file covered by a piece.
+type Segment struct {
+	File   int   // index of the file in Files, or 0 in a single-file torrent
+	Offset int64 // offset of the segment within the file
+	Length int64 // length of the segment
+}
+
+// PieceSegments returns, in order, the segments of the files covered by the nth piece. Zero length files are never included.
+func (i InfoDict) PieceSegments(n int) []Segment {
+	off, length := i.PieceBounds(n)
+	if i.Length > 0 {
+		return []Segment{{0, off, length}}
+	}
+	var segs []Segment
+	var foff int64
+	for j, f := range i.Files {
+		if length <= 0 {
+			break
+		}
+		if off < foff+f.Length {
+			seg := Segment{j, off - foff, foff + f.Length - off}
+			if seg.Length > length {
+				seg.Length = length
+			}
+			segs = append(segs, seg)
+			off += seg.Length
+			length -= seg.Length
+		}
+		foff += f.Length
+	}
+	return segs
+}
+
+// FilePieces returns the range of pieces [first, end) which cover the nth file, or the first file in a single-file torrent.
+// Zero length files are covered by no pieces, so first == end.
+func (i InfoDict) FilePieces(n int) (first, end int) {
+	var off, length int64
+	if i.Length > 0 {
+		length = i.Length
+	} else {
+		for _, f := range i.Files[:n] {
+			off += f.Length
+		}
+		length = i.Files[n].Length
+	}
+	first = int(off / i.PieceLength)
+	if length == 0 {
+		return first, first
+	}
+	return first, int((off + length + i.PieceLength - 1) / i.PieceLength)
+}
+
 func (i InfoDict) Infohash(h hash.Hash) []byte {
 	var buf bytes.Buffer
 	Encode(&buf, i)
@@ -53,8 +203,31 @@ func (i InfoDict) Infohash(h hash.Hash) []byte {
 }
 
 type File struct {
-	Length int64    `bencode:"length"`
-	Path   []string `bencode:"path"`
+	Attr        string   `bencode:"attr,ommitempty"`
+	Length      int64    `bencode:"length"`
+	Path        []string `bencode:"path"`
+	SHA1        []byte   `bencode:"sha1,ommitempty"`
+	SymlinkPath []string `bencode:"symlink path,ommitempty"`
+}
+
+// IsPadding reports whether f is a BEP 47 padding file, whose contents are all zeros and which is not stored on disk.
+func (f File) IsPadding() bool {
+	return strings.ContainsRune(f.Attr, 'p')
+}
+
+// IsExecutable reports whether f should be marked executable.
+func (f File) IsExecutable() bool {
+	return strings.ContainsRune(f.Attr, 'x')
+}
+
+// IsHidden reports whether f should be marked hidden.
+func (f File) IsHidden() bool {
+	return strings.ContainsRune(f.Attr, 'h')
+}
+
+// IsSymlink reports whether f is a symbolic link to SymlinkPath, relative to the root of the torrent.
+func (f File) IsSymlink() bool {
+	return strings.ContainsRune(f.Attr, 'l')
 }
 
 type CompactTrackerResponse struct {
@@ -164,6 +337,10 @@ func isEmptyValue(v reflect.Value) bool {
 		return v.Float() == 0
 	case reflect.Interface, reflect.Ptr:
 		return v.IsNil()
+	case reflect.Struct:
+		if v.Type() == typeOfTime {
+			return v.Interface().(time.Time).IsZero()
+		}
 	}
 	return false
 }
@@ -224,6 +401,26 @@ func encodeT(w *bufio.Writer, v reflect.Value) error {
 			}
 		}
 		_ = w.WriteByte('e')
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return errors.Errorf("cannot bencode map with %v keys", v.Type().Key())
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		_ = w.WriteByte('d')
+		for _, k := range keys {
+			_, _ = fmt.Fprintf(w, "%d:%s", k.Len(), k.String())
+			err := encodeT(w, v.MapIndex(k))
+			if err != nil {
+				return err
+			}
+		}
+		_ = w.WriteByte('e')
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return errors.Errorf("cannot bencode nil %v", v.Type())
+		}
+		return encodeT(w, v.Elem())
 	default:
 		return errors.Errorf("cannot bencode type %v", v)
 	}
@@ -247,6 +444,23 @@ func decodeT(r *bufio.Reader, v reflect.Value) error {
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	if v.IsValid() && v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		// Decode into the natural type of the value, as encoding/json does.
+		t := typeOfString
+		switch b {
+		case 'i':
+			t = typeOfInt64
+		case 'l':
+			t = typeOfList
+		case 'd':
+			t = typeOfDict
+		}
+		_ = r.UnreadByte()
+		g := reflect.New(t).Elem()
+		err = decodeT(r, g)
+		v.Set(g)
+		return err
+	}
 	switch b {
 	case 'i':
 		return decodeInt(r, v)
@@ -262,7 +476,13 @@ func decodeT(r *bufio.Reader, v reflect.Value) error {
 	}
 }
 
-var typeOfTime = reflect.TypeOf(time.Time{})
+var (
+	typeOfTime   = reflect.TypeOf(time.Time{})
+	typeOfString = reflect.TypeOf("")
+	typeOfInt64  = reflect.TypeOf(int64(0))
+	typeOfList   = reflect.TypeOf([]interface{}(nil))
+	typeOfDict   = reflect.TypeOf(map[string]interface{}(nil))
+)
 
 func decodeInt(r *bufio.Reader, v reflect.Value) error {
 	switch {
@@ -332,6 +552,9 @@ func decodeString(r io.Reader, v reflect.Value) error {
 		v.SetBytes(buf)
 	case v.Kind() == reflect.String:
 		v.SetString(string(buf))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		// Lists of strings, such as url-list, may be abbreviated to a single string.
+		v.Set(reflect.Append(v.Slice(0, 0), reflect.ValueOf(string(buf)).Convert(v.Type().Elem())))
 	default:
 		return errors.Errorf("cannot store string in %v", v.Type())
 	}
@@ -369,9 +592,13 @@ func decodeList(r *bufio.Reader, v reflect.Value) error {
 
 func decodeDict(r *bufio.Reader, v reflect.Value) error {
 	//BUG: Will not return an error when leading spaces or zeros are present in an int
-	if v.IsValid() && v.Kind() != reflect.Struct {
+	isMap := v.IsValid() && v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String
+	if v.IsValid() && v.Kind() != reflect.Struct && !isMap {
 		return errors.Errorf("cannot decode dictionary into %v", v.Type())
 	}
+	if isMap && v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
 	var lastName []byte
 	for {
 		b, err := r.ReadByte()
@@ -399,16 +626,23 @@ func decodeDict(r *bufio.Reader, v reflect.Value) error {
 			return errors.Errorf("%q appeared after %q in dict despite being lexiographically smaller", name, lastName)
 		}
 		lastName = name
-		if v.IsValid() {
+		switch {
+		case isMap:
+			elem := reflect.New(v.Type().Elem()).Elem()
+			err = decodeT(r, elem)
+			v.SetMapIndex(reflect.ValueOf(string(name)).Convert(v.Type().Key()), elem)
+		case v.IsValid():
 			i := structIndexFromName(v.Type(), string(name))
 			if i >= 0 {
 				err = decodeT(r, v.Field(i))
 			} else {
 				err = decodeT(r, reflect.Value{})
 			}
-			if err != nil {
-				return err
-			}
+		default:
+			err = decodeT(r, reflect.Value{})
+		}
+		if err != nil {
+			return err
 		}
 	}
 }
@@ -0,0 +1,146 @@
+package bencode
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/debian-9.1.0-amd64-netinst.iso.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Metainfo
+	err = Decode(bytes.NewReader(b), &m)
+	if err != nil {
+		t.Errorf("%+v", err)
+	}
+	var buf bytes.Buffer
+	err = Encode(&buf, m)
+	if err != nil {
+		t.Errorf("%+v", err)
+	}
+	t.Logf("\n%+q\n\t%#v\n%+q\n", b, m, buf.Bytes())
+	if bytes.Equal(buf.Bytes(), b) == false {
+		t.Error("Encode(Decode(b)) != b")
+	}
+}
+
+func TestInfohash(t *testing.T) {
+	const infohash = "\xfd\x5f\xdf\x21\xae\xf4\x50\x54\x51\x86\x1d\xa9\x7a\xa3\x90\x00\xed\x85\x29\x88"
+	b, err := ioutil.ReadFile("testdata/debian-9.1.0-amd64-netinst.iso.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Metainfo
+	err = Decode(bytes.NewReader(b), &m)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var buf bytes.Buffer
+	Encode(&buf, m.Info)
+	t.Logf("infodict:\n%+q\n", buf.Bytes())
+	if bytes.Equal(m.Info.Infohash(sha1.New()), []byte(infohash)) == false {
+		t.Error("calculated infohash is incorrect")
+	}
+}
+
+func TestPieceSegments(t *testing.T) {
+	i := InfoDict{
+		PieceLength: 4,
+		RawPieces:   make([]byte, 4*20),
+		Files: []File{
+			{Length: 3},
+			{Length: 0},
+			{Length: 6},
+			{Length: 1},
+			{Length: 3},
+		},
+	}
+	segments := [][]Segment{
+		{{0, 0, 3}, {2, 0, 1}},
+		{{2, 1, 4}},
+		{{2, 5, 1}, {3, 0, 1}, {4, 0, 2}},
+		{{4, 2, 1}},
+	}
+	for n, want := range segments {
+		if got := i.PieceSegments(n); !reflect.DeepEqual(got, want) {
+			t.Errorf("i.PieceSegments(%d) = %v, expected %v", n, got, want)
+		}
+	}
+	pieces := [][2]int{{0, 1}, {0, 0}, {0, 3}, {2, 3}, {2, 4}}
+	for n, want := range pieces {
+		if first, end := i.FilePieces(n); first != want[0] || end != want[1] {
+			t.Errorf("i.FilePieces(%d) = %d, %d, expected %d, %d", n, first, end, want[0], want[1])
+		}
+	}
+}
+
+func TestPieceSegments_SingleFile(t *testing.T) {
+	i := InfoDict{
+		PieceLength: 4,
+		RawPieces:   make([]byte, 3*20),
+		Length:      10,
+	}
+	if got, want := i.PieceSegments(2), []Segment{{0, 8, 2}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("i.PieceSegments(2) = %v, expected %v", got, want)
+	}
+	if first, end := i.FilePieces(0); first != 0 || end != 3 {
+		t.Errorf("i.FilePieces(0) = %d, %d, expected 0, 3", first, end)
+	}
+}
+
+func TestFileTree(t *testing.T) {
+	m := Metainfo{
+		Info: InfoDict{
+			Name:        "root",
+			PieceLength: 16384,
+			MetaVersion: 2,
+			FileTree: FileTree{
+				"b": FileTree{"": V2File{Length: 3, PiecesRoot: []byte("rootb")}},
+				"a": FileTree{
+					"c": FileTree{"": V2File{Length: 0}},
+				},
+			},
+		},
+		PieceLayers: map[string][]byte{"rootb": []byte("layer")},
+	}
+	var buf bytes.Buffer
+	err := Encode(&buf, m)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	const want = "d8:announce0:4:infod9:file treed1:ad1:cd0:d6:lengthi0eeee1:bd0:d6:lengthi3e11:pieces root5:rootbeee" +
+		"12:meta versioni2e4:name4:root12:piece lengthi16384ee12:piece layersd5:rootb5:layeree"
+	if buf.String() != want {
+		t.Errorf("Encode() = %q, expected %q", buf.String(), want)
+	}
+	var m0 Metainfo
+	err = Decode(bytes.NewReader(buf.Bytes()), &m0)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	file := m0.Info.FileTree["b"].(map[string]interface{})[""].(map[string]interface{})
+	if file["length"] != int64(3) || file["pieces root"] != "rootb" || string(m0.PieceLayers["rootb"]) != "layer" {
+		t.Errorf("decoded %#v", m0)
+	}
+	want0 := []File{{Length: 0, Path: []string{"a", "c"}}, {Length: 3, Path: []string{"b"}}}
+	if files := m.Info.FileTree.Files(); !reflect.DeepEqual(files, want0) {
+		t.Errorf("m.Info.FileTree.Files() = %+v, expected %+v", files, want0)
+	}
+	if files := m0.Info.FileTree.Files(); !reflect.DeepEqual(files, want0) {
+		t.Errorf("m0.Info.FileTree.Files() = %+v, expected %+v", files, want0)
+	}
+	// Unknown dictionaries, such as the file tree of a struct without one, are skipped.
+	var i struct {
+		Name string `bencode:"name"`
+	}
+	err = Decode(strings.NewReader("d9:file treed1:ad0:d6:lengthi1eeee4:name1:xe"), &i)
+	if err != nil || i.Name != "x" {
+		t.Errorf("Decode() = %+v, name %q", err, i.Name)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"runtime"
+
+	"github.com/pkg/errors"
+	"github.com/takeyourhatoff/bt/bencode"
+	"github.com/takeyourhatoff/bt/internal/bitset"
+	"github.com/takeyourhatoff/bt/internal/iox"
+	"github.com/takeyourhatoff/bt/internal/multifile"
+	"github.com/takeyourhatoff/bt/internal/piece"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	bitfield = flag.String("bitfield", "", "write the bitfield of good pieces to file")
+	jsonOut  = flag.String("json", "", "write a JSON report to file (- for stdout)")
+	quiet    = flag.Bool("quiet", false, "do not print a report")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] file.torrent dir\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Checks the data in dir against file.torrent, exiting with status 2 if it is incomplete.\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	m, err := readMetainfo(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(m.Info.RawPieces) == 0 && m.Info.MetaVersion == 2 {
+		log.Fatalf("%s: v2 only torrents cannot be checked", flag.Arg(0))
+	}
+	f, err := multifile.OpenPartial(flag.Arg(1), m)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	have, err := check(piece.NewStore(f, m.Info))
+	if err != nil {
+		log.Fatal(err)
+	}
+	r := newReport(m.Info, have)
+	if *bitfield != "" {
+		err = ioutil.WriteFile(*bitfield, have.BytesN(r.Pieces), 0666)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *jsonOut != "" {
+		err = writeJSON(*jsonOut, r)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if !*quiet {
+		r.print(os.Stdout)
+	}
+	if r.GoodPieces != r.Pieces {
+		os.Exit(2)
+	}
+}
+
+func readMetainfo(name string) (bencode.Metainfo, error) {
+	var m bencode.Metainfo
+	f, err := os.Open(name)
+	if err != nil {
+		return m, err
+	}
+	defer f.Close()
+	err = bencode.Decode(f, &m)
+	return m, err
+}
+
+// check verifies every piece in s in parallel, returning the set of good pieces. Pieces of files which are missing
+// are bad.
+func check(s *piece.Store) (*bitset.Bitset, error) {
+	g, ctx := errgroup.WithContext(context.Background())
+	have := bitset.NewAtomic(s.NumPieces())
+	c := make(chan int)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		g.Go(func() error {
+			for i := range c {
+				err := s.VerifyPiece(i)
+				if cause := errors.Cause(err); cause == iox.ErrChecksum || cause == multifile.ErrMissing {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				have.Add(i)
+			}
+			return nil
+		})
+	}
+	g.Go(func() error {
+		defer close(c)
+		for i := 0; i < s.NumPieces(); i++ {
+			select {
+			case c <- i:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		return nil
+	})
+	err := g.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return have.Snapshot(), nil
+}
+
+type report struct {
+	Infohash   string       `json:"infohash"`
+	Pieces     int          `json:"pieces"`
+	GoodPieces int          `json:"good_pieces"`
+	Complete   float64      `json:"complete"`
+	BadPieces  []int        `json:"bad_pieces"`
+	Files      []fileReport `json:"files"`
+}
+
+type fileReport struct {
+	Path     string  `json:"path"`
+	Length   int64   `json:"length"`
+	Complete float64 `json:"complete"`
+}
+
+func newReport(info bencode.InfoDict, have *bitset.Bitset) report {
+	r := report{
+		Infohash:   fmt.Sprintf("%x", info.Infohash(sha1.New())),
+		Pieces:     info.NumPieces(),
+		GoodPieces: have.Count(),
+		BadPieces:  []int{},
+	}
+	r.Complete = completion(info, have, 0, info.TotalLength())
+	for i := 0; i < r.Pieces; i++ {
+		if !have.Get(i) {
+			r.BadPieces = append(r.BadPieces, i)
+		}
+	}
+	if info.Length > 0 {
+		r.Files = []fileReport{{info.Name, info.Length, r.Complete}}
+		return r
+	}
+	var off int64
+	for _, fi := range info.Files {
+		if !fi.IsPadding() {
+			r.Files = append(r.Files, fileReport{
+				Path:     path.Join(append([]string{info.Name}, fi.Path...)...),
+				Length:   fi.Length,
+				Complete: completion(info, have, off, fi.Length),
+			})
+		}
+		off += fi.Length
+	}
+	return r
+}
+
+// completion returns the fraction of the bytes in [off, off+length) which are in good pieces.
+func completion(info bencode.InfoDict, have *bitset.Bitset, off, length int64) float64 {
+	if length == 0 {
+		return 1
+	}
+	var good int64
+	end := off + length
+	for i := int(off / info.PieceLength); i < info.NumPieces() && int64(i)*info.PieceLength < end; i++ {
+		if !have.Get(i) {
+			continue
+		}
+		poff, plen := info.PieceBounds(i)
+		good += min(poff+plen, end) - max(poff, off)
+	}
+	return float64(good) / float64(length)
+}
+
+func (r report) print(w io.Writer) {
+	fmt.Fprintf(w, "infohash: %s\n", r.Infohash)
+	fmt.Fprintf(w, "pieces: %d/%d (%.2f%%)\n", r.GoodPieces, r.Pieces, r.Complete*100)
+	if len(r.BadPieces) > 0 {
+		fmt.Fprintf(w, "bad pieces:")
+		for _, i := range r.BadPieces {
+			fmt.Fprintf(w, " %d", i)
+		}
+		fmt.Fprintln(w)
+	}
+	for _, f := range r.Files {
+		fmt.Fprintf(w, "%7.2f%% %s\n", f.Complete*100, f.Path)
+	}
+}
+
+func writeJSON(name string, r report) (err error) {
+	if name == "-" {
+		return json.NewEncoder(os.Stdout).Encode(r)
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err0 := f.Close(); err == nil {
+			err = err0
+		}
+	}()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+	err = enc.Encode(r)
+	return
+}
+
+func min(i, j int64) int64 {
+	if i < j {
+		return i
+	}
+	return j
+}
+
+func max(i, j int64) int64 {
+	if i > j {
+		return i
+	}
+	return j
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/takeyourhatoff/bt/bencode"
+	"github.com/takeyourhatoff/bt/internal/bitset"
+	"github.com/takeyourhatoff/bt/internal/piece"
+)
+
+func TestReport(t *testing.T) {
+	info := bencode.InfoDict{
+		Name:        "test_torrent",
+		PieceLength: 4,
+		RawPieces:   make([]byte, 4*20),
+		Files: []bencode.File{
+			{Length: 6,
+				Path: []string{"a"}},
+			{Length: 2,
+				Path: []string{".pad", "2"},
+				Attr: "p"},
+			{Length: 6,
+				Path: []string{"b"}},
+		},
+	}
+	have := new(bitset.Bitset)
+	have.Add(0)
+	have.Add(3)
+	r := newReport(info, have)
+	if r.GoodPieces != 2 || r.Pieces != 4 {
+		t.Errorf("%d/%d good pieces, expected 2/4", r.GoodPieces, r.Pieces)
+	}
+	if want := []int{1, 2}; len(r.BadPieces) != 2 || r.BadPieces[0] != want[0] || r.BadPieces[1] != want[1] {
+		t.Errorf("bad pieces = %v, expected %v", r.BadPieces, want)
+	}
+	want := []fileReport{
+		{"test_torrent/a", 6, 4.0 / 6},
+		{"test_torrent/b", 6, 2.0 / 6},
+	}
+	if len(r.Files) != len(want) {
+		t.Fatalf("report has %d files, expected %d", len(r.Files), len(want))
+	}
+	for i := range want {
+		if r.Files[i] != want[i] {
+			t.Errorf("file %d = %+v, expected %+v", i, r.Files[i], want[i])
+		}
+	}
+}
+
+// failingFile is a multifile.File whose reads always fail, as those of a bad disk might.
+type failingFile struct{ size int64 }
+
+func (f failingFile) ReadAt(p []byte, off int64) (int, error) {
+	return 0, errors.New("input/output error")
+}
+func (f failingFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, errors.New("input/output error")
+}
+func (f failingFile) Size() int64  { return f.size }
+func (f failingFile) Close() error { return nil }
+
+func TestCheck_ReadErrors(t *testing.T) {
+	// Many more pieces than workers, so that the sender would block if it ignored the failed workers.
+	n := 100 * runtime.NumCPU()
+	info := bencode.InfoDict{Name: "test_torrent", PieceLength: 4, Length: int64(4 * n), RawPieces: make([]byte, 20*n)}
+	done := make(chan error)
+	go func() {
+		_, err := check(piece.NewStore(failingFile{info.Length}, info))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("check succeeded when every read failed")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("check hung when every read failed")
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/takeyourhatoff/bt/maketorrent"
+)
+
+// item is one torrent to be created in batch mode.
+type item struct {
+	path string
+	out  string
+}
+
+// batchResult is the outcome of creating the torrent of one item.
+type batchResult struct {
+	Path    string   `json:"path"`
+	Output  string   `json:"output"`
+	Status  string   `json:"status"` // created, skipped or failed
+	Error   string   `json:"error,omitempty"`
+	Elapsed float64  `json:"elapsed"`
+	Torrent *summary `json:"torrent,omitempty"`
+}
+
+// batchReport is the -json report of batch mode.
+type batchReport struct {
+	Created int           `json:"created"`
+	Skipped int           `json:"skipped"`
+	Failed  int           `json:"failed"`
+	Items   []batchResult `json:"items"`
+}
+
+// dirItems returns an item for each file and directory in dir, other than torrents, checkpoints, including the
+// temporary files they are written to, and outDir, and hidden ones if skipHidden is set. Torrents are written to
+// outDir, or else dir.
+func dirItems(dir, outDir string, skipHidden bool) ([]item, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if outDir == "" {
+		outDir = dir
+	}
+	outFi, _ := os.Stat(outDir)
+	var items []item
+	for _, fi := range fis {
+		name := fi.Name()
+		if strings.HasSuffix(name, ".torrent") || isCheckpoint(name) || skipHidden && name[0] == '.' {
+			continue
+		}
+		if outFi != nil && os.SameFile(fi, outFi) {
+			continue
+		}
+		items = append(items, item{filepath.Join(dir, name), filepath.Join(outDir, name+".torrent")})
+	}
+	return items, nil
+}
+
+// isCheckpoint reports whether name is that of a checkpoint, or of the temporary file, name.checkpoint.NNN, which one
+// is written to and which is left behind if mktorrent is killed.
+func isCheckpoint(name string) bool {
+	if strings.HasSuffix(name, ".checkpoint") {
+		return true
+	}
+	i := strings.LastIndex(name, ".checkpoint.")
+	if i < 0 {
+		return false
+	}
+	for _, r := range name[i+len(".checkpoint."):] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return len(name) > i+len(".checkpoint.")
+}
+
+// readManifest returns an item for each path listed in r, one per line, where blank lines and those starting with #
+// are ignored. Relative paths are relative to dir. Torrents are written to outDir, or else beside each item.
+func readManifest(r io.Reader, dir, outDir string) ([]item, error) {
+	var items []item
+	outs := make(map[string]string)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		path := filepath.Clean(line)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		out := path + ".torrent"
+		if outDir != "" {
+			out = filepath.Join(outDir, filepath.Base(path)+".torrent")
+		}
+		if prev, ok := outs[out]; ok {
+			return nil, errors.Errorf("%s and %s would both be written to %s", prev, path, out)
+		}
+		outs[out] = path
+		items = append(items, item{path, out})
+	}
+	return items, s.Err()
+}
+
+// upToDate reports whether the torrent out exists, is the torrent b would build of path other than its hashes, and
+// no file or directory in path has been modified since hashing of it began, as readPrevious tells. Directories are
+// included so that removing a file makes the torrent out of date. Anything modified in the same second as hashing
+// began is taken to have been modified after it was hashed.
+func upToDate(b maketorrent.Builder, path, out string) (bool, error) {
+	if _, err := os.Stat(out); os.IsNotExist(err) {
+		return false, nil
+	}
+	m, since, err := readPrevious(out)
+	if err != nil {
+		// An unreadable torrent is replaced.
+		return false, nil
+	}
+	if ok, err := b.Matches(m, path); !ok || err != nil {
+		return false, err
+	}
+	errStale := errors.New("stale")
+	err = filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.ModTime().Before(since) {
+			return errStale
+		}
+		return nil
+	})
+	if err == errStale {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// runBatch creates the torrent of each item with b, jobs at a time, skipping those which are up to date unless force
+// is set. Torrents of v1 items which are out of date reuse the hashes of files which have not changed. report, if
+// not nil, is called as each item finishes. Items whose torrent cannot be created are reported as failed, and do not
+// stop the others unless ctx is done.
+func runBatch(ctx context.Context, b maketorrent.Builder, items []item, jobs int, force bool, report func(batchResult)) batchReport {
+	if jobs < 1 {
+		jobs = 1
+	}
+	results := make([]batchResult, len(items))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex // guards calls to report
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range next {
+				results[n] = batchItem(ctx, b, items[n], force)
+				if report != nil {
+					mu.Lock()
+					report(results[n])
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for n := range items {
+		next <- n
+	}
+	close(next)
+	wg.Wait()
+
+	r := batchReport{Items: results}
+	for _, res := range results {
+		switch res.Status {
+		case "created":
+			r.Created++
+		case "skipped":
+			r.Skipped++
+		default:
+			r.Failed++
+		}
+	}
+	return r
+}
+
+// batchItem creates the torrent of it with b, unless it is up to date and force is not set.
+func batchItem(ctx context.Context, b maketorrent.Builder, it item, force bool) batchResult {
+	start := time.Now()
+	res := batchResult{Path: it.path, Output: it.out}
+	fail := func(err error) batchResult {
+		res.Status, res.Error = "failed", err.Error()
+		res.Elapsed = time.Since(start).Seconds()
+		return res
+	}
+	if err := ctx.Err(); err != nil {
+		return fail(err)
+	}
+	if !force {
+		ok, err := upToDate(b, it.path, it.out)
+		if err != nil {
+			return fail(err)
+		}
+		if ok {
+			res.Status = "skipped"
+			return res
+		}
+	}
+	if b.Format == maketorrent.V1 {
+		if m, since, err := readPrevious(it.out); err == nil {
+			b.Previous, b.PreviousTime = &m.Info, since
+		}
+	}
+	if b.CheckpointInterval > 0 {
+		b.Checkpoint = it.out + ".checkpoint"
+	}
+	m, err := create(ctx, b, it.path, it.out)
+	if err != nil {
+		return fail(err)
+	}
+	s := newSummary(it.out, m)
+	res.Status, res.Torrent = "created", &s
+	res.Elapsed = time.Since(start).Seconds()
+	return res
+}
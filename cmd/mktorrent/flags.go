@@ -0,0 +1,33 @@
+package main
+
+import "strings"
+
+// listFlag is a flag which may be given more than once, collecting every value.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// tiers returns the announce-list tiers given by l, where each value is a comma separated list of the trackers in
+// one tier.
+func (l listFlag) tiers() [][]string {
+	var tiers [][]string
+	for _, s := range l {
+		var tier []string
+		for _, url := range strings.Split(s, ",") {
+			if url = strings.TrimSpace(url); url != "" {
+				tier = append(tier, url)
+			}
+		}
+		if len(tier) > 0 {
+			tiers = append(tiers, tier)
+		}
+	}
+	return tiers
+}
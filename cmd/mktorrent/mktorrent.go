@@ -1,34 +1,81 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha1"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"log"
+	"math/bits"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"runtime"
 	"runtime/pprof"
+	"strconv"
+	"syscall"
 	"time"
 
-	"github.com/takeyourhatoff/bt/internal/bencode"
-	"github.com/takeyourhatoff/bt/internal/multifile"
-	"golang.org/x/sync/errgroup"
+	"github.com/pkg/errors"
+
+	"github.com/takeyourhatoff/bt/bencode"
+	"github.com/takeyourhatoff/bt/internal/iox"
+	"github.com/takeyourhatoff/bt/maketorrent"
 )
 
-const defaultComment = "created by cbv0"
+const createdBy = "cbv0"
 
 var (
 	out           = flag.String("out", "", "output file name (default: $path.torrent)")
-	announce      = flag.String("announce", "", "announce url")
-	comment       = flag.String("comment", defaultComment, "comment")
+	comment       = flag.String("comment", "", "comment")
+	source        = flag.String("source", "", "source tag, which changes the infohash so that private trackers can tell cross-seeded torrents apart")
+	creator       = flag.String("created-by", createdBy, "name of the program creating the torrent")
+	noDate        = flag.Bool("no-date", false, "leave out the creation date")
+	date          = flag.String("date", "", "creation date, as seconds since the epoch or RFC 3339 (default: $SOURCE_DATE_EPOCH, or now)")
 	private       = flag.Bool("private", false, "private flag")
-	lgPieceLength = flag.Uint("piece-length", 20, "lg(piece-length) (default: 1MB)")
+	lgPieceLength = flag.Uint("piece-length", 0, "lg(piece-length), or 0 to choose from the total size")
+	targetPieces  = flag.Int("target-pieces", maketorrent.DefaultTargetPieces, "number of pieces to aim for when choosing the piece length")
+	targetSize    = flag.Int64("target-size", 0, "size in bytes of the piece hashes to aim for when choosing the piece length, instead of -target-pieces")
+	skipHidden    = flag.Bool("skip-hidden", false, "leave out files and directories whose names start with a dot")
+	defaultExcl   = flag.Bool("default-exclude", false, "leave out common junk such as .DS_Store, Thumbs.db, editor swap files and version control directories")
+	previous      = flag.String("previous", "", "earlier torrent of the same files, whose hashes are reused for files which have not been modified since it was hashed")
+	verifyPrev    = flag.Bool("verify-previous", false, "hash the pieces reused from -previous anyway, and fail if any has changed; use it where modification times are coarse, such as on FAT")
+	v2            = flag.Bool("v2", false, "create a BEP 52 v2 torrent")
+	hybrid        = flag.Bool("hybrid", false, "create a torrent which is both v1 and v2")
+	align         = flag.Bool("align", false, "insert padding files so that every file starts on a piece boundary")
 	cpuprofile    = flag.String("cpuprofile", "", "write cpuprofile to file")
+	checkpoint    = flag.String("checkpoint", "", "file to save hashing progress to, so that it can be resumed (default: $out.checkpoint)")
+	cpInterval    = flag.Duration("checkpoint-interval", maketorrent.DefaultCheckpointInterval, "how often to save the hashing progress of v1 torrents, or 0 to never save it")
+	resume        = flag.Bool("resume", false, "resume hashing from the -checkpoint file, if the files have not changed since it was saved")
+	magnet        = flag.Bool("magnet", false, "print a magnet link")
+	jsonOut       = flag.Bool("json", false, "print a JSON summary of the torrent instead of its infohashes")
+	quiet         = flag.Bool("quiet", false, "do not report progress")
+	machine       = flag.Bool("machine", false, "report progress as one JSON object per line, even when not on a terminal")
+	batch         = flag.Bool("batch", false, "create a torrent of each file and directory in the directory given, instead of one of it")
+	manifest      = flag.String("manifest", "", "create a torrent of each path listed, one per line, in the named file, where relative paths are relative to its directory")
+	outDir        = flag.String("out-dir", "", "directory to write the torrents of -batch or -manifest to (default: beside each item)")
+	jobs          = flag.Int("jobs", 2, "number of torrents of -batch or -manifest to create at once")
+	force         = flag.Bool("force", false, "recreate the torrents of -batch or -manifest even if they are up to date")
+	workers       = flag.Int("workers", 0, "number of pieces to hash at once, shared by every torrent being created (default: one per CPU)")
+	readRate      = flag.String("read-rate", "unlimited", "limit on the rate at which files are read, shared by every torrent being created, such as 200MB/s")
 )
 
+var (
+	announce listFlag
+	webSeeds listFlag
+	include  listFlag
+	exclude  listFlag
+	symlinks maketorrent.SymlinkPolicy
+)
+
+func init() {
+	flag.Var(&announce, "announce", "comma separated announce urls of one tier of trackers (repeatable)")
+	flag.Var(&webSeeds, "web-seed", "web seed url (repeatable)")
+	flag.Var(&include, "include", "only include files matching the glob, which is matched against the path if it contains a slash (repeatable)")
+	flag.Var(&exclude, "exclude", "leave out files and directories matching the glob, which is matched against the path if it contains a slash (repeatable)")
+	flag.Var(&symlinks, "symlinks", "what to do with symlinks: follow, skip, or store as BEP 47 symlinks those which point into the torrent")
+}
+
 func main() {
 	flag.Parse()
 	if *cpuprofile != "" {
@@ -40,6 +87,16 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
+	b := builder()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *batch || *manifest != "" {
+		if code := mainBatch(ctx, b); code != 0 {
+			os.Exit(code)
+		}
+		return
+	}
+
 	if flag.Arg(0) == "" {
 		flag.Usage()
 		os.Exit(1)
@@ -48,114 +105,257 @@ func main() {
 	if *out == "" {
 		*out = name + ".torrent"
 	}
-	var i bencode.Metainfo
-	i.Announce = *announce
-	i.Info.PieceLength = 1 << *lgPieceLength
-	i.Info.Name = filepath.Base(name)
-	i.Info.Private = *private
-	i.CreationDate = time.Now()
-	i.Comment = *comment
+	if *previous != "" {
+		m, since, err := readPrevious(*previous)
+		if err != nil {
+			log.Fatal(err)
+		}
+		b.Previous, b.PreviousTime, b.Verify = &m.Info, since, *verifyPrev
+	}
+	if b.CheckpointInterval > 0 {
+		b.Checkpoint = *checkpoint
+		if b.Checkpoint == "" {
+			b.Checkpoint = *out + ".checkpoint"
+		}
+	}
+	p := newProgress(os.Stderr, *quiet, *machine)
+	if p != nil {
+		b.Progress = p.update
+	}
 
-	err := makeMetainfo(name, &i)
+	i, err := create(ctx, b, name, *out)
+	p.stop()
 	if err != nil {
+		if _, err0 := os.Stat(b.Checkpoint); b.Checkpoint != "" && err0 == nil && errors.Cause(err) != maketorrent.ErrStaleCheckpoint {
+			log.Printf("progress saved to %s, run again with -resume to continue", b.Checkpoint)
+		}
 		log.Fatal(err)
 	}
-	err = writeMetainfo(*out, i)
+	s := newSummary(*out, i)
+	if *jsonOut {
+		if err := printJSON(s); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if s.Infohash != "" {
+		fmt.Printf("infohash: %s\n", s.Infohash)
+	}
+	if s.InfohashV2 != "" {
+		fmt.Printf("infohash v2: %s\n", s.InfohashV2)
+	}
+	if *magnet {
+		fmt.Printf("magnet: %s\n", s.Magnet)
+	}
+}
+
+// builder returns a Builder with the options given by the flags, other than those which depend on the path being
+// made into a torrent.
+func builder() maketorrent.Builder {
+	b := maketorrent.Builder{
+		TargetPieces: *targetPieces,
+		TargetSize:   *targetSize,
+		Trackers:     announce.tiers(),
+		WebSeeds:     webSeeds,
+		Private:      *private,
+		Source:       *source,
+		Comment:      *comment,
+		CreatedBy:    *creator,
+		Include:      include,
+		Exclude:      exclude,
+		SkipHidden:   *skipHidden,
+		Symlinks:     symlinks,
+		Align:        *align,
+	}
+	switch {
+	case *v2 && *hybrid:
+		log.Fatal("-v2 and -hybrid cannot be used together")
+	case *v2:
+		b.Format = maketorrent.V2
+	case *hybrid:
+		b.Format = maketorrent.Hybrid
+	}
+	if *previous != "" && b.Format != maketorrent.V1 {
+		log.Fatal("-previous can only be used for v1 torrents")
+	}
+	if (*checkpoint != "" || *resume) && b.Format != maketorrent.V1 {
+		log.Fatal("-checkpoint and -resume can only be used for v1 torrents")
+	}
+	if (*checkpoint != "" || *resume) && *cpInterval <= 0 {
+		log.Fatal("-checkpoint and -resume cannot be used with -checkpoint-interval 0")
+	}
+	if *cpInterval > 0 && b.Format == maketorrent.V1 {
+		b.CheckpointInterval = *cpInterval
+		b.Resume = *resume
+	}
+	if *defaultExcl {
+		b.Exclude = append(b.Exclude, maketorrent.DefaultExclude...)
+	}
+	if *lgPieceLength != 0 {
+		lgMin, lgMax := uint(bits.Len(maketorrent.MinPieceLength-1)), uint(bits.Len(maketorrent.MaxPieceLength-1))
+		if *lgPieceLength < lgMin || *lgPieceLength > lgMax {
+			log.Fatalf("-piece-length %d: must be between %d and %d", *lgPieceLength, lgMin, lgMax)
+		}
+		b.PieceLength = 1 << *lgPieceLength
+	}
+	if *targetPieces <= 0 {
+		log.Fatal("-target-pieces must be positive")
+	}
+	if *targetSize != 0 && *targetSize < sha1.Size {
+		log.Fatalf("-target-size %d: must be at least %d, the size of one piece hash", *targetSize, sha1.Size)
+	}
+	rate, err := iox.ParseRate(*readRate)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("-read-rate: %v", err)
 	}
-	fmt.Printf("infohash: %x\n", i.Info.Infohash(sha1.New()))
+	b.Pool = maketorrent.NewPool(*workers, rate)
+	return b
 }
 
-func makeMetainfo(name string, i *bencode.Metainfo) error {
-	info, err := os.Stat(name)
+// create builds the torrent of name with b and writes it to out, dated as the -no-date and -date flags say. The
+// modification time of out is set to when hashing began, whatever the date, so that readPrevious can tell which
+// files were modified after they were hashed. The checkpoint of b is removed only once out has been written.
+func create(ctx context.Context, b maketorrent.Builder, name, out string) (bencode.Metainfo, error) {
+	m, err := b.Build(ctx, name)
 	if err != nil {
-		return err
+		return m, err
 	}
-	if info.IsDir() {
-		err = filepath.Walk(name, func(name0 string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if info.IsDir() {
-				return nil
-			}
-			relname, err := filepath.Rel(name, name0)
-			if err != nil {
-				return err
-			}
-			i.Info.Files = append(i.Info.Files, bencode.File{
-				Length: info.Size(),
-				Path:   filepath.SplitList(relname),
-			})
-			return nil
-		})
+	hashed := m.CreationDate
+	if *noDate {
+		m.CreationDate = time.Time{}
+	} else {
+		m.CreationDate, err = creationDate(*date, os.Getenv("SOURCE_DATE_EPOCH"), hashed)
 		if err != nil {
-			return err
+			return m, err
 		}
-	} else {
-		i.Info.Length = info.Size()
 	}
-
-	f, err := multifile.Open(filepath.Dir(name), *i)
+	err = maketorrent.WriteFile(out, m)
 	if err != nil {
-		return err
+		return m, err
 	}
-	p, err := pieces(f, int64(i.Info.PieceLength))
+	err = os.Chtimes(out, hashed, hashed)
 	if err != nil {
-		return err
+		return m, err
 	}
-	i.Info.RawPieces = bytes.Join(p, nil)
-	return nil
+	return m, b.RemoveCheckpoint()
 }
 
-type sizeReaderAt interface {
-	io.ReaderAt
-	Size() int64
-}
+// mainBatch creates the torrents of -batch or -manifest with b, printing a report of them, and returns the exit
+// status.
+func mainBatch(ctx context.Context, b maketorrent.Builder) int {
+	if *out != "" || *checkpoint != "" || *previous != "" {
+		log.Fatal("-out, -checkpoint and -previous cannot be used with -batch or -manifest")
+	}
+	var items []item
+	var err error
+	switch {
+	case *batch && *manifest != "":
+		log.Fatal("-batch and -manifest cannot be used together")
+	case *batch:
+		if flag.Arg(0) == "" {
+			flag.Usage()
+			return 1
+		}
+		items, err = dirItems(flag.Arg(0), *outDir, *skipHidden)
+	default:
+		var f *os.File
+		f, err = os.Open(*manifest)
+		if err != nil {
+			log.Fatal(err)
+		}
+		items, err = readManifest(f, filepath.Dir(*manifest), *outDir)
+		f.Close()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *outDir != "" {
+		if err := os.MkdirAll(*outDir, 0777); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-func pieces(r sizeReaderAt, len int64) ([][]byte, error) {
-	var g errgroup.Group
-	numPieces := int((r.Size() + len - 1) / len)
-	p := make([][]byte, numPieces)
-	c := make(chan int)
-	for i := 0; i < runtime.NumCPU(); i++ {
-		g.Go(func() error {
-			h := sha1.New()
-			var buf []byte
-			for i := range c {
-				rr := io.NewSectionReader(r, int64(i)*len, len)
-				_, err := io.Copy(h, rr)
-				if err != nil {
-					return err
+	var report func(batchResult)
+	if !*quiet {
+		report = func(r batchResult) {
+			log.Printf("%s %s", r.Status, r.Path)
+		}
+	}
+	r := runBatch(ctx, b, items, *jobs, *force, report)
+	if *jsonOut {
+		if err := printJSON(r); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		for _, res := range r.Items {
+			switch res.Status {
+			case "created":
+				ih := res.Torrent.Infohash
+				if ih == "" {
+					ih = res.Torrent.InfohashV2
 				}
-				p[i] = h.Sum(buf[:0])
-				h.Reset()
+				fmt.Printf("created  %s  %s\n", res.Output, ih)
+			case "skipped":
+				fmt.Printf("skipped  %s\n", res.Output)
+			default:
+				fmt.Printf("failed   %s: %s\n", res.Path, res.Error)
 			}
-			return nil
-		})
+		}
+		fmt.Printf("%d created, %d skipped, %d failed\n", r.Created, r.Skipped, r.Failed)
 	}
-	for i := 0; i < numPieces; i++ {
-		c <- i
+	if r.Failed > 0 {
+		return 1
 	}
-	close(c)
-	err := g.Wait()
-	if err != nil {
-		return nil, err
+	return 0
+}
+
+// printJSON prints v to stdout as indented JSON.
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+// creationDate returns the date given by the -date flag, or else by $SOURCE_DATE_EPOCH, so that builds can be
+// reproduced, or else def.
+func creationDate(flag, env string, def time.Time) (time.Time, error) {
+	if flag != "" {
+		if t, err := time.Parse(time.RFC3339, flag); err == nil {
+			return t, nil
+		}
+		sec, err := strconv.ParseInt(flag, 10, 64)
+		if err != nil {
+			return time.Time{}, errors.Errorf("-date %q: expected seconds since the epoch or RFC 3339", flag)
+		}
+		return time.Unix(sec, 0), nil
+	}
+	if env != "" {
+		sec, err := strconv.ParseInt(env, 10, 64)
+		if err != nil {
+			return time.Time{}, errors.Errorf("SOURCE_DATE_EPOCH %q: expected seconds since the epoch", env)
+		}
+		return time.Unix(sec, 0), nil
 	}
-	return p, nil
+	return def, nil
 }
 
-func writeMetainfo(name string, i bencode.Metainfo) (err error) {
-	f, err := os.Create(name)
+// readPrevious reads the torrent in the named file, returning it and the time hashing of it began. Files
+// modified before then are assumed to be unchanged. create records that time as both the creation date, unless
+// another is given, and the modification time of the file, and the earlier of the two is used in case either has
+// since been changed to a later time.
+func readPrevious(name string) (bencode.Metainfo, time.Time, error) {
+	fi, err := os.Stat(name)
 	if err != nil {
-		return
+		return bencode.Metainfo{}, time.Time{}, err
 	}
-	defer func() {
-		if err0 := f.Close(); err == nil {
-			err = err0
-		}
-	}()
-	err = bencode.Encode(f, i)
-	return
+	m, err := maketorrent.ReadFile(name)
+	if err != nil {
+		return m, time.Time{}, err
+	}
+	since := fi.ModTime()
+	if !m.CreationDate.IsZero() && m.CreationDate.Before(since) {
+		since = m.CreationDate
+	}
+	return m, since, nil
 }
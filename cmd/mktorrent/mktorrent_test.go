@@ -1,6 +1,242 @@
 package main
 
-import "testing"
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
 
-func BenchPieces(b *testing.B) {
+	"github.com/takeyourhatoff/bt/bencode"
+	"github.com/takeyourhatoff/bt/maketorrent"
+)
+
+func TestTiers(t *testing.T) {
+	l := listFlag{"http://a/announce, http://b/announce", "", "udp://c:80,"}
+	want := [][]string{{"http://a/announce", "http://b/announce"}, {"udp://c:80"}}
+	if got := l.tiers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("tiers() = %q, expected %q", got, want)
+	}
+}
+
+func TestCreationDate(t *testing.T) {
+	tests := []struct {
+		flag, env string
+		want      int64
+	}{
+		{"", "1500000000", 1500000000},
+		{"1600000000", "1500000000", 1600000000},
+		{"2020-09-13T12:26:40Z", "", 1600000000},
+		{"", "", 1400000000},
+	}
+	for _, tt := range tests {
+		got, err := creationDate(tt.flag, tt.env, time.Unix(1400000000, 0))
+		if err != nil || got.Unix() != tt.want {
+			t.Errorf("creationDate(%q, %q) = %v, %v, expected %d", tt.flag, tt.env, got.Unix(), err, tt.want)
+		}
+	}
+	for _, bad := range [][2]string{{"yesterday", ""}, {"", "soon"}} {
+		if _, err := creationDate(bad[0], bad[1], time.Time{}); err == nil {
+			t.Errorf("creationDate(%q, %q) succeeded", bad[0], bad[1])
+		}
+	}
+}
+
+func TestNewSummary(t *testing.T) {
+	files := []bencode.File{
+		{Length: 5, Path: []string{"a"}},
+		{Length: 16379, Path: []string{".pad", "16379"}, Attr: "p"},
+		{Length: 20000, Path: []string{"d", "b"}},
+	}
+	m := bencode.Metainfo{Info: bencode.InfoDict{Name: "root", PieceLength: 16384, RawPieces: make([]byte, 3*20), Files: files}}
+	s := newSummary("root.torrent", m)
+	want := []fileSummary{{"root/a", 5}, {"root/d/b", 20000}}
+	if s.Pieces != 3 || s.Size != 20005 || s.Infohash == "" || s.InfohashV2 != "" || !reflect.DeepEqual(s.Files, want) {
+		t.Errorf("newSummary() = %+v", s)
+	}
+
+	m.Info.RawPieces, m.Info.Files, m.Info.MetaVersion = nil, nil, 2
+	m.Info.FileTree = bencode.FileTree{
+		"a": bencode.FileTree{"": bencode.V2File{Length: 5}},
+		"d": bencode.FileTree{"b": bencode.FileTree{"": bencode.V2File{Length: 20000}}},
+	}
+	s = newSummary("root.torrent", m)
+	if s.Pieces != 3 || s.Size != 20005 || s.Infohash != "" || s.InfohashV2 == "" || !reflect.DeepEqual(s.Files, want) {
+		t.Errorf("newSummary() = %+v", s)
+	}
+
+	m.Info.FileTree = bencode.FileTree{"root": bencode.FileTree{"": bencode.V2File{Length: 5}}}
+	s = newSummary("root.torrent", m)
+	if want := []fileSummary{{"root", 5}}; s.Pieces != 1 || !reflect.DeepEqual(s.Files, want) {
+		t.Errorf("newSummary() = %+v", s)
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	r := strings.NewReader("# comment\na\n\n  /abs/b  \nc/d/\n")
+	items, err := readManifest(r, "/m", "")
+	want := []item{{"/m/a", "/m/a.torrent"}, {"/abs/b", "/abs/b.torrent"}, {"/m/c/d", "/m/c/d.torrent"}}
+	if err != nil || !reflect.DeepEqual(items, want) {
+		t.Errorf("readManifest() = %q, %v, expected %q", items, err, want)
+	}
+	if _, err := readManifest(strings.NewReader("x/a\ny/a\n"), "/m", "/out"); err == nil {
+		t.Error("readManifest() with two items written to the same torrent succeeded")
+	}
+}
+
+func TestBuilder_Defaults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{".git/HEAD", "a", "a~"} {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte("data"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Without any flags, nothing is left out.
+	b := builder()
+	m, err := b.Build(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, fi := range m.Info.Files {
+		got = append(got, strings.Join(fi.Path, "/"))
+	}
+	if want := []string{".git/HEAD", "a", "a~"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %q, expected %q", got, want)
+	}
+}
+
+func TestCreate_Checkpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "a")
+	if err := ioutil.WriteFile(name, make([]byte, 100000), 0666); err != nil {
+		t.Fatal(err)
+	}
+	cp := filepath.Join(dir, "a.checkpoint")
+	b := maketorrent.Builder{Checkpoint: cp, Pool: maketorrent.NewPool(1, 0)}
+	// The progress is kept if the torrent cannot be written.
+	if _, err := create(context.Background(), b, name, filepath.Join(dir, "missing", "a.torrent")); err == nil {
+		t.Fatal("create() into a missing directory succeeded")
+	}
+	if _, err := os.Stat(cp); err != nil {
+		t.Errorf("checkpoint removed after failing to write the torrent: %v", err)
+	}
+	if _, err := create(context.Background(), b, name, filepath.Join(dir, "a.torrent")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cp); !os.IsNotExist(err) {
+		t.Errorf("checkpoint not removed after writing the torrent: %v", err)
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// The files were modified well before the torrents are hashed.
+	modified := time.Now().Add(-2 * time.Hour)
+	for name, data := range map[string]string{"a/x": "hello", "a/y": "world", "b": "file", ".hidden": "x"} {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chtimes(filepath.Join(dir, "a"), modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "out"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if items, err := dirItems(dir, filepath.Join(dir, "out"), true); err != nil || len(items) != 2 {
+		t.Errorf("dirItems() = %q, %v, expected the output directory to be left out", items, err)
+	}
+	if err := os.Remove(filepath.Join(dir, "out")); err != nil {
+		t.Fatal(err)
+	}
+	items, err := dirItems(dir, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := maketorrent.Builder{Pool: maketorrent.NewPool(1, 0)}
+	run := func(force bool, created, skipped int) {
+		t.Helper()
+		r := runBatch(context.Background(), b, items, 2, force, nil)
+		if r.Created != created || r.Skipped != skipped || r.Failed != 0 {
+			t.Errorf("runBatch() = %+v, expected %d created and %d skipped", r, created, skipped)
+		}
+	}
+	run(false, 2, 0)
+	if items, err := dirItems(dir, "", true); err != nil || len(items) != 2 {
+		t.Errorf("dirItems() = %q, %v, expected the torrents to be left out", items, err)
+	}
+	run(false, 0, 2)
+	// Modify b after its torrent was hashed.
+	now := time.Now()
+	if err := os.Chtimes(filepath.Join(dir, "b"), now, now); err != nil {
+		t.Fatal(err)
+	}
+	run(false, 1, 1)
+	run(true, 2, 0)
+
+	// Anything modified in the second that hashing began may have changed after it was hashed.
+	fi, err := os.Stat(filepath.Join(dir, "a.torrent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "a", "x"), fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "b"), modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	run(false, 1, 1)
+
+	// Torrents built with other options are out of date.
+	b.Trackers = [][]string{{"http://tracker/announce"}}
+	run(false, 2, 0)
+	// As are those whose padding or files would differ, which only a's would.
+	if err := os.Chtimes(filepath.Join(dir, "a", "x"), modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	b.Align = true
+	run(false, 1, 1)
+	b.Exclude = []string{"y"}
+	run(false, 1, 1)
+	run(false, 0, 2)
+
+	// The temporary files of checkpoints left behind by a crash are not items.
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.torrent.checkpoint.123456"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if items, err := dirItems(dir, "", true); err != nil || len(items) != 2 {
+		t.Errorf("dirItems() = %q, %v, expected checkpoints to be left out", items, err)
+	}
+
+	r := runBatch(context.Background(), b, []item{{filepath.Join(dir, "missing"), filepath.Join(dir, "missing.torrent")}}, 1, false, nil)
+	if r.Failed != 1 || r.Items[0].Error == "" {
+		t.Errorf("runBatch() of a missing item = %+v", r)
+	}
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/takeyourhatoff/bt/internal/iox"
+)
+
+// progress reports the progress of hashing to w, either as a line which is continuously redrawn on a terminal, or as
+// one JSON object per line for other programs to read.
+type progress struct {
+	w       io.Writer
+	machine bool
+	m       *iox.Meter
+	hashed  int64
+	total   int64
+	start   time.Time
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// newProgress returns a progress which reports to w, or nil if nothing should be reported.
+func newProgress(w *os.File, quiet, machine bool) *progress {
+	if quiet {
+		return nil
+	}
+	if !machine && !isTerminal(w) {
+		return nil
+	}
+	return &progress{
+		w:       w,
+		machine: machine,
+		m:       iox.NewMeter(nil, 5*time.Second),
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// update records that hashed out of total bytes have been hashed. It is a maketorrent.Builder's Progress callback,
+// and starts reporting when it is first called.
+func (p *progress) update(hashed, total int64) {
+	if p.done == nil {
+		p.run(total)
+	}
+	p.m.Mark(int(hashed - p.hashed))
+	p.hashed = hashed
+}
+
+// run starts reporting progress towards total bytes hashed.
+func (p *progress) run(total int64) {
+	p.total = total
+	p.start = time.Now()
+	p.done = make(chan struct{})
+	p.stopped = make(chan struct{})
+	interval := 200 * time.Millisecond
+	if p.machine {
+		interval = time.Second
+	}
+	go func() {
+		defer close(p.stopped)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				p.report(false)
+			case <-p.done:
+				p.report(true)
+				return
+			}
+		}
+	}()
+}
+
+// stop reports the final progress and stops reporting. It does nothing if reporting has not started or has already
+// stopped.
+func (p *progress) stop() {
+	if p == nil || p.done == nil {
+		return
+	}
+	close(p.done)
+	<-p.stopped
+	p.done = nil
+}
+
+type progressLine struct {
+	Hashed  int64   `json:"hashed"`
+	Total   int64   `json:"total"`
+	Rate    float64 `json:"rate"`
+	ETA     float64 `json:"eta"`
+	Elapsed float64 `json:"elapsed"`
+	Done    bool    `json:"done"`
+}
+
+func (p *progress) report(done bool) {
+	l := progressLine{
+		Hashed:  p.m.Total(),
+		Total:   p.total,
+		Rate:    p.m.Rates()[0],
+		Elapsed: time.Since(p.start).Seconds(),
+		Done:    done,
+	}
+	if done || l.Elapsed < 5 {
+		// Use the overall average once finished, or before the moving average has settled.
+		l.Rate = float64(l.Hashed) / l.Elapsed
+	}
+	if !done && l.Rate > 0 {
+		l.ETA = float64(l.Total-l.Hashed) / l.Rate
+	}
+	if p.machine {
+		json.NewEncoder(p.w).Encode(l)
+		return
+	}
+	var pct float64
+	if l.Total > 0 {
+		pct = float64(l.Hashed) / float64(l.Total) * 100
+	}
+	fmt.Fprintf(p.w, "\r\033[K%s / %s (%.1f%%) %s/s", formatBytes(float64(l.Hashed)), formatBytes(float64(l.Total)), pct, formatBytes(l.Rate))
+	if done {
+		fmt.Fprintf(p.w, " in %v\n", time.Duration(l.Elapsed*float64(time.Second)).Round(time.Second))
+	} else if l.Rate > 0 {
+		fmt.Fprintf(p.w, " ETA %v", time.Duration(l.ETA*float64(time.Second)).Round(time.Second))
+	}
+}
+
+func formatBytes(n float64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	exp := 0
+	for n >= unit*unit && exp < 4 {
+		n /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", n/unit, "KMGTP"[exp])
+}
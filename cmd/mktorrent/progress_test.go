@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/takeyourhatoff/bt/internal/iox"
+)
+
+// testProgress returns a progress reporting to w which began two seconds ago and has hashed 1 MiB of 4 MiB.
+func testProgress(w *bytes.Buffer, machine bool) *progress {
+	p := &progress{
+		w:       w,
+		machine: machine,
+		m:       iox.NewMeter(nil, 5*time.Second),
+		total:   4 << 20,
+		start:   time.Now().Add(-2 * time.Second),
+	}
+	p.m.Mark(1 << 20)
+	return p
+}
+
+func TestProgress_Machine(t *testing.T) {
+	var buf bytes.Buffer
+	p := testProgress(&buf, true)
+	p.report(false)
+	p.report(true)
+
+	d := json.NewDecoder(&buf)
+	for _, done := range []bool{false, true} {
+		var l map[string]interface{}
+		if err := d.Decode(&l); err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range []string{"hashed", "total", "rate", "eta", "elapsed", "done"} {
+			if _, ok := l[k]; !ok {
+				t.Errorf("report(%v): no %q in %v", done, k, l)
+			}
+		}
+		if l["hashed"] != float64(1<<20) || l["total"] != float64(4<<20) || l["done"] != done {
+			t.Errorf("report(%v) = %v, expected 1 MiB of 4 MiB hashed", done, l)
+		}
+		elapsed, _ := l["elapsed"].(float64)
+		if elapsed < 2 || elapsed > 3 {
+			t.Errorf("report(%v): elapsed = %v, expected about 2", done, l["elapsed"])
+		}
+		// Before the moving average settles, and once done, the rate is the overall average.
+		rate, _ := l["rate"].(float64)
+		if want := float64(1<<20) / elapsed; math.Abs(rate-want) > want/100 {
+			t.Errorf("report(%v): rate = %v, expected %v", done, rate, want)
+		}
+		eta, _ := l["eta"].(float64)
+		want := float64(3<<20) / rate
+		if done {
+			want = 0
+		}
+		if math.Abs(eta-want) > 0.01 {
+			t.Errorf("report(%v): eta = %v, expected %v", done, eta, want)
+		}
+	}
+}
+
+func TestProgress_Terminal(t *testing.T) {
+	var buf bytes.Buffer
+	p := testProgress(&buf, false)
+	p.report(false)
+	got := buf.String()
+	if !strings.HasPrefix(got, "\r\033[K1.0 MiB / 4.0 MiB (25.0%) ") || !strings.HasSuffix(got, " ETA 6s") {
+		t.Errorf("report(false) wrote %q, expected 1.0 MiB / 4.0 MiB (25.0%%) ... ETA 6s", got)
+	}
+
+	buf.Reset()
+	p.report(true)
+	got = buf.String()
+	if !strings.HasPrefix(got, "\r\033[K1.0 MiB / 4.0 MiB (25.0%) ") || !strings.HasSuffix(got, "/s in 2s\n") {
+		t.Errorf("report(true) wrote %q, expected 1.0 MiB / 4.0 MiB (25.0%%) ... in 2s", got)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    float64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{1 << 20, "1.0 MiB"},
+		{5 << 30, "5.0 GiB"},
+		{1 << 40, "1.0 TiB"},
+		{1 << 50, "1.0 PiB"},
+		{1 << 60, "1024.0 PiB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%v) = %q, expected %q", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+
+	"github.com/takeyourhatoff/bt/bencode"
+	"github.com/takeyourhatoff/bt/maketorrent"
+)
+
+// summary is the -json report of a created torrent.
+type summary struct {
+	Output      string        `json:"output"`
+	Name        string        `json:"name"`
+	Infohash    string        `json:"infohash,omitempty"`
+	InfohashV2  string        `json:"infohash_v2,omitempty"`
+	PieceLength int64         `json:"piece_length"`
+	Pieces      int           `json:"pieces"`
+	Size        int64         `json:"size"`
+	Files       []fileSummary `json:"files"`
+	Magnet      string        `json:"magnet"`
+}
+
+type fileSummary struct {
+	Path   string `json:"path"`
+	Length int64  `json:"length"`
+}
+
+// newSummary returns the summary of the torrent m, written to the file out.
+func newSummary(out string, m bencode.Metainfo) summary {
+	s := summary{
+		Output:      out,
+		Name:        m.Info.Name,
+		PieceLength: m.Info.PieceLength,
+		Magnet:      maketorrent.Magnet(m),
+	}
+	v1 := m.Info.MetaVersion != 2 || len(m.Info.RawPieces) > 0
+	if v1 {
+		s.Infohash = hex.EncodeToString(m.Info.Infohash(sha1.New()))
+		s.Pieces = m.Info.NumPieces()
+	}
+	if m.Info.MetaVersion == 2 {
+		s.InfohashV2 = hex.EncodeToString(m.Info.Infohash(sha256.New()))
+	}
+
+	var files []bencode.File
+	single := false
+	switch {
+	case v1 && len(m.Info.Files) == 0:
+		files, single = []bencode.File{{Length: m.Info.Length}}, true
+	case v1:
+		files = m.Info.Files
+	default:
+		files = m.Info.FileTree.Files()
+		single = len(files) == 1 && len(files[0].Path) == 1
+	}
+	for _, f := range files {
+		if f.IsPadding() {
+			continue
+		}
+		name := m.Info.Name
+		if !single {
+			name = path.Join(append([]string{name}, f.Path...)...)
+		}
+		s.Files = append(s.Files, fileSummary{name, f.Length})
+		s.Size += f.Length
+		if !v1 {
+			// Each file of a v2 torrent has its own pieces.
+			s.Pieces += int((f.Length + m.Info.PieceLength - 1) / m.Info.PieceLength)
+		}
+	}
+	return s
+}
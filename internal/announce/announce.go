@@ -13,7 +13,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
-	"github.com/takeyourhatoff/bt/internal/bencode"
+	"github.com/takeyourhatoff/bt/bencode"
 )
 
 type EventType int
@@ -11,7 +11,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
-	"github.com/takeyourhatoff/bt/internal/bencode"
+	"github.com/takeyourhatoff/bt/bencode"
 )
 
 func readMetainfo(name string) (bencode.Metainfo, error) {
@@ -0,0 +1,146 @@
+package bitset
+
+import (
+	"math/bits"
+	"runtime"
+	"sync/atomic"
+)
+
+// Atomic represents a set of integers in [0, n) for a fixed n. It is safe for concurrent use, and Add, Remove, Get
+// and Count are lock-free.
+type Atomic struct {
+	// removes counts the calls to Remove: the low 32 bits those in progress, and the high 32 bits those finished.
+	// It is first so that it is 64-bit aligned on 32-bit platforms.
+	removes uint64
+	s       []uint64
+	n       int
+}
+
+// NewAtomic returns an empty set which can hold the integers in [0, n).
+func NewAtomic(n int) *Atomic {
+	if n < 0 {
+		panic("bitset: negative capacity")
+	}
+	return &Atomic{
+		s: make([]uint64, (n+63)/64),
+		n: n,
+	}
+}
+
+// Len returns n, the capacity of s.
+func (s *Atomic) Len() int {
+	return s.n
+}
+
+// Add adds the integer i to s, returning whether it was not already in s. It panics if i is not in [0, n).
+func (s *Atomic) Add(i int) bool {
+	w, mask := s.idx(i)
+	for {
+		old := atomic.LoadUint64(w)
+		if old&mask != 0 {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(w, old, old|mask) {
+			return true
+		}
+	}
+}
+
+// Remove removes the integer i from s, returning whether it was in s. It panics if i is not in [0, n).
+func (s *Atomic) Remove(i int) bool {
+	w, mask := s.idx(i)
+	atomic.AddUint64(&s.removes, 1)
+	defer atomic.AddUint64(&s.removes, 1<<32-1)
+	for {
+		old := atomic.LoadUint64(w)
+		if old&mask == 0 {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(w, old, old&^mask) {
+			return true
+		}
+	}
+}
+
+// Get returns whether i is in s.
+func (s *Atomic) Get(i int) bool {
+	if i < 0 || i >= s.n {
+		return false
+	}
+	w, mask := s.idx(i)
+	return atomic.LoadUint64(w)&mask != 0
+}
+
+// Count returns the number of integers in s.
+func (s *Atomic) Count() int {
+	var n int
+	for i := range s.s {
+		n += bits.OnesCount64(atomic.LoadUint64(&s.s[i]))
+	}
+	return n
+}
+
+// Snapshot returns a copy of s as a Bitset, which is the contents of s at a single instant during the call.
+//
+// The copy is taken by reading s until two consecutive reads agree with no call to Remove in progress or finished
+// between them. As Add only ever sets bits, nothing can then have changed between the reads. Snapshot retries for as
+// long as s keeps changing, so a caller which Adds and Removes integers without pause may keep it waiting.
+func (s *Atomic) Snapshot() *Bitset {
+	cur := make([]uint64, len(s.s))
+	prev := make([]uint64, len(s.s))
+	for {
+		removes := atomic.LoadUint64(&s.removes)
+		if uint32(removes) != 0 {
+			runtime.Gosched()
+			continue
+		}
+		s.collect(prev)
+		s.collect(cur)
+		if equal64(cur, prev) && atomic.LoadUint64(&s.removes) == removes {
+			return fromUint64s(cur)
+		}
+	}
+}
+
+func (s *Atomic) collect(dst []uint64) {
+	for i := range s.s {
+		dst[i] = atomic.LoadUint64(&s.s[i])
+	}
+}
+
+func (s *Atomic) idx(i int) (w *uint64, mask uint64) {
+	if i < 0 || i >= s.n {
+		panic("bitset: integer out of range")
+	}
+	return &s.s[i/64], 1 << (uint(i) % 64)
+}
+
+func equal64(a, b []uint64) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// fromUint64s returns the Bitset whose integer i is set if bit i%64 of s[i/64] is set.
+func fromUint64s(s []uint64) *Bitset {
+	ss := new(Bitset)
+	switch bits.UintSize {
+	case 32:
+		ss.s = make([]uint, 2*len(s))
+		for i, w := range s {
+			ss.s[2*i] = uint(uint32(w))
+			ss.s[2*i+1] = uint(w >> 32)
+		}
+	case 64:
+		ss.s = make([]uint, len(s))
+		for i, w := range s {
+			ss.s[i] = uint(w)
+		}
+	default:
+		panic("uint is not 32 or 64 bits long")
+	}
+	return ss
+}
@@ -0,0 +1,124 @@
+package bitset
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"testing/quick"
+)
+
+func TestAtomic(t *testing.T) {
+	f := func(l ascendingInts) bool {
+		n := 1
+		if len(l) > 0 {
+			n = l[len(l)-1] + 1
+		}
+		s := NewAtomic(n)
+		var wg sync.WaitGroup
+		for g := 0; g < runtime.NumCPU(); g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for j := g; j < len(l); j += runtime.NumCPU() {
+					s.Add(l[j])
+				}
+			}(g)
+		}
+		wg.Wait()
+		if c := s.Count(); c != len(l) {
+			t.Logf("s.Count() = %d, expected %d", c, len(l))
+			return false
+		}
+		if ss := s.Snapshot(); !ss.Equal(fromInts(l)) {
+			t.Logf("s.Snapshot() = %v, expected %v", ss, l)
+			return false
+		}
+		for _, i := range l {
+			if !s.Get(i) {
+				t.Logf("s.Get(%d) = false, expected true", i)
+				return false
+			}
+			if s.Add(i) {
+				t.Logf("s.Add(%d) = true for an integer already in s", i)
+				return false
+			}
+			if !s.Remove(i) || s.Get(i) {
+				t.Logf("s.Remove(%d) did not remove it", i)
+				return false
+			}
+		}
+		return s.Count() == 0
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAtomic_Snapshot(t *testing.T) {
+	const n = 1 << 14
+	s := NewAtomic(n)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Adding in descending order means a consistent snapshot is always a suffix of [0, n).
+		for i := n - 1; i >= 0; i-- {
+			s.Add(i)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			if c := s.Snapshot().Count(); c != n {
+				t.Errorf("s.Snapshot().Count() = %d, expected %d", c, n)
+			}
+			return
+		default:
+		}
+		ss := s.Snapshot()
+		if min := ss.NextAfter(0); min >= 0 && ss.Count() != n-min {
+			t.Fatalf("inconsistent snapshot: minimum %d with %d integers", min, ss.Count())
+		}
+	}
+}
+
+func BenchmarkAtomic_Add(b *testing.B) {
+	s := NewAtomic(1 << 20)
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			s.Add(i & (1<<20 - 1))
+			i += 4099
+		}
+	})
+}
+
+func TestAtomic_SnapshotRemove(t *testing.T) {
+	s := NewAtomic(128)
+	s.Add(0)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// 0 and 64 are in different words, and at least one of them is always in s.
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			s.Add(64)
+			s.Remove(0)
+			s.Add(0)
+			s.Remove(64)
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+	for i := 0; i < 10000; i++ {
+		if c := s.Snapshot().Count(); c == 0 {
+			t.Fatal("inconsistent snapshot: neither 0 nor 64 in s")
+		}
+	}
+}
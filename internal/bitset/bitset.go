@@ -3,6 +3,7 @@ package bitset
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"math/bits"
 )
@@ -20,9 +21,7 @@ func (s *Bitset) Add(i int) {
 		panic("bitset: cannot add non-negative integer to set")
 	}
 	w, mask := idx(i)
-	for j := len(s.s); j <= w; j++ {
-		s.s = append(s.s, 0)
-	}
+	s.grow(w + 1)
 	s.s[w] |= mask
 }
 
@@ -75,6 +74,154 @@ func (s *Bitset) AndNot(ss *Bitset) {
 	}
 }
 
+// Or adds the integers in ss to s.
+func (s *Bitset) Or(ss *Bitset) {
+	s.grow(len(ss.s))
+	for i := range ss.s {
+		s.s[i] |= ss.s[i]
+	}
+}
+
+// Xor sets s to the integers which are in exactly one of s and ss.
+func (s *Bitset) Xor(ss *Bitset) {
+	s.grow(len(ss.s))
+	for i := range ss.s {
+		s.s[i] ^= ss.s[i]
+	}
+}
+
+// Equal returns whether s and ss contain the same integers.
+func (s *Bitset) Equal(ss *Bitset) bool {
+	a, b := s.s, ss.s
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	for i := range b {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	for _, w := range a[len(b):] {
+		if w != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// IsSubset returns whether every integer in s is also in ss.
+func (s *Bitset) IsSubset(ss *Bitset) bool {
+	for i, w := range s.s {
+		var w1 uint
+		if i < len(ss.s) {
+			w1 = ss.s[i]
+		}
+		if w&^w1 != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Complement sets s to the integers in [0, n) which are not in s.
+func (s *Bitset) Complement(n int) {
+	if n <= 0 {
+		s.s = s.s[:0]
+		return
+	}
+	w, _ := idx(n - 1)
+	s.grow(w + 1)
+	s.s = s.s[:w+1]
+	for i := range s.s {
+		s.s[i] = ^s.s[i]
+	}
+	if r := uint(n) % bits.UintSize; r != 0 {
+		s.s[w] &= 1<<r - 1
+	}
+}
+
+// AddRange adds the integers in [i, j) to s.
+func (s *Bitset) AddRange(i, j int) {
+	if i < 0 {
+		panic("bitset: cannot add non-negative integer to set")
+	}
+	if i >= j {
+		return
+	}
+	w, _ := idx(j - 1)
+	s.grow(w + 1)
+	s.setRange(i, j, true)
+}
+
+// RemoveRange removes the integers in [i, j) from s.
+func (s *Bitset) RemoveRange(i, j int) {
+	if i < 0 {
+		i = 0
+	}
+	if max := len(s.s) * bits.UintSize; j > max {
+		j = max
+	}
+	if i >= j {
+		return
+	}
+	s.setRange(i, j, false)
+}
+
+// setRange adds or removes the integers in [i, j), where 0 <= i < j and j is within the words of s.
+func (s *Bitset) setRange(i, j int, add bool) {
+	wi, wj := i/bits.UintSize, (j-1)/bits.UintSize
+	for w := wi; w <= wj; w++ {
+		mask := uint(maxUint)
+		if w == wi {
+			mask &= maxUint << (uint(i) % bits.UintSize)
+		}
+		if w == wj {
+			mask &= maxUint >> (bits.UintSize - 1 - (uint(j)-1)%bits.UintSize)
+		}
+		if add {
+			s.s[w] |= mask
+		} else {
+			s.s[w] &^= mask
+		}
+	}
+}
+
+// Rank returns the number of integers in s which are less than i.
+func (s *Bitset) Rank(i int) int {
+	if i <= 0 {
+		return 0
+	}
+	w, _ := idx(i)
+	var n int
+	for j := 0; j < w && j < len(s.s); j++ {
+		n += bits.OnesCount(s.s[j])
+	}
+	if w < len(s.s) {
+		n += bits.OnesCount(s.s[w] & (1<<(uint(i)%bits.UintSize) - 1))
+	}
+	return n
+}
+
+// Select returns the integer in s with rank k, that is the (k+1)th smallest, or -1 if s has k or fewer integers.
+// For all integers i in s, s.Select(s.Rank(i)) == i.
+func (s *Bitset) Select(k int) int {
+	if k < 0 {
+		return -1
+	}
+	for j, w := range s.s {
+		n := bits.OnesCount(w)
+		if k >= n {
+			k -= n
+			continue
+		}
+		for ; k > 0; k-- {
+			w &= w - 1
+		}
+		return j*bits.UintSize + bits.TrailingZeros(w)
+	}
+	return -1
+}
+
 // Count returns the number of integers in the s.
 func (s *Bitset) Count() int {
 	var n int
@@ -101,6 +248,62 @@ func (s *Bitset) NextAfter(i int) int {
 	return -1
 }
 
+// NextClear returns the smallest non-negative integer not in s which is greater than or equal to i.
+func (s *Bitset) NextClear(i int) int {
+	if i < 0 {
+		i = 0
+	}
+	mask := uint(maxUint) << (uint(i) % bits.UintSize)
+	for j := i / bits.UintSize; j < len(s.s); j++ {
+		word := ^s.s[j] & mask
+		mask = maxUint
+		if word != 0 {
+			return j*bits.UintSize + bits.TrailingZeros(word)
+		}
+	}
+	if max := len(s.s) * bits.UintSize; i < max {
+		return max
+	}
+	return i
+}
+
+// Iterator returns an Iterator over the integers in s in ascending order.
+func (s *Bitset) Iterator() *Iterator {
+	return &Iterator{s: s, w: -1}
+}
+
+// Iterator iterates over the integers in a Bitset one word at a time. The Bitset must not be modified during iteration.
+//
+//	for it := s.Iterator(); it.Next(); {
+//		fmt.Println(it.Value())
+//	}
+type Iterator struct {
+	s    *Bitset
+	w    int  // index of the current word
+	word uint // the bits of the current word not yet returned
+	v    int
+}
+
+// Next advances the iterator to the next integer, returning false when there are no more.
+func (it *Iterator) Next() bool {
+	for it.word == 0 {
+		it.w++
+		if it.w >= len(it.s.s) {
+			it.w = len(it.s.s)
+			return false
+		}
+		it.word = it.s.s[it.w]
+	}
+	it.v = it.w*bits.UintSize + bits.TrailingZeros(it.word)
+	it.word &= it.word - 1
+	return true
+}
+
+// Value returns the integer the iterator is at.
+func (it *Iterator) Value() int {
+	return it.v
+}
+
 // Copy returns a copy of s.
 func (s *Bitset) Copy() *Bitset {
 	n := len(s.s)
@@ -118,11 +321,11 @@ func (s *Bitset) String() string {
 	var buf bytes.Buffer
 	buf.WriteRune('[')
 	first := true
-	for i := s.NextAfter(0); i >= 0; i = s.NextAfter(i + 1) {
+	for it := s.Iterator(); it.Next(); {
 		if !first {
 			buf.WriteRune(' ')
 		}
-		fmt.Fprintf(&buf, "%d", i)
+		fmt.Fprintf(&buf, "%d", it.Value())
 		first = false
 	}
 	buf.WriteRune(']')
@@ -152,6 +355,37 @@ func (s *Bitset) Bytes() []byte {
 	return b0
 }
 
+// BytesN returns the set as a bitfield of exactly (n+7)/8 bytes, the length of the bitfield of a torrent with n pieces.
+// Integers in s which are greater than or equal to n are not included.
+func (s *Bitset) BytesN(n int) []byte {
+	b := s.Bytes()
+	size := (n + 7) / 8
+	if len(b) < size {
+		return append(b, make([]byte, size-len(b))...)
+	}
+	b = b[:size]
+	if n%8 != 0 {
+		b[size-1] &= byte(0xff) << uint(8-n%8)
+	}
+	return b
+}
+
+// FromBitfield sets s to the bitfield data of a torrent with n pieces.
+// It returns an error, leaving s unchanged, if data is not exactly (n+7)/8 bytes long or has any of its spare trailing bits set.
+func (s *Bitset) FromBitfield(data []byte, n int) error {
+	if n < 0 {
+		return fmt.Errorf("bitset: invalid number of pieces %d", n)
+	}
+	if size := (n + 7) / 8; len(data) != size {
+		return fmt.Errorf("bitset: bitfield has length %d, expected %d", len(data), size)
+	}
+	if n%8 != 0 && data[len(data)-1]&(byte(0xff)>>uint(n%8)) != 0 {
+		return errors.New("bitset: bitfield has spare bits set")
+	}
+	s.FromBytes(append([]byte(nil), data...))
+	return nil
+}
+
 func (s *Bitset) FromBytes(data []byte) *Bitset {
 	const r = bits.UintSize / 8
 	if len(data) == 0 {
@@ -176,6 +410,13 @@ func (s *Bitset) FromBytes(data []byte) *Bitset {
 	return s
 }
 
+// grow ensures s has at least n words.
+func (s *Bitset) grow(n int) {
+	for j := len(s.s); j < n; j++ {
+		s.s = append(s.s, 0)
+	}
+}
+
 func idx(i int) (w int, mask uint) {
 	w = i / bits.UintSize
 	mask = 1 << (uint(i) % bits.UintSize)
@@ -243,3 +243,263 @@ func (l ascendingInts) Generate(rand *rand.Rand, size int) reflect.Value {
 	}
 	return reflect.ValueOf(l)
 }
+
+func TestBytesN(t *testing.T) {
+	f := func(l ascendingInts, n uint16) bool {
+		b := new(Bitset)
+		for _, i := range l {
+			b.Add(int(i))
+		}
+		data := b.BytesN(int(n))
+		if len(data) != (int(n)+7)/8 {
+			t.Logf("len(b.BytesN(%d)) = %d, expected %d", n, len(data), (int(n)+7)/8)
+			return false
+		}
+		b1 := new(Bitset)
+		if err := b1.FromBitfield(data, int(n)); err != nil {
+			t.Logf("b1.FromBitfield(b.BytesN(%d), %d) = %v", n, n, err)
+			return false
+		}
+		for i := 0; i < int(n)+8; i++ {
+			if want := i < int(n) && b.Get(i); b1.Get(i) != want {
+				t.Logf("b1.Get(%d) = %v, expected %v", i, b1.Get(i), want)
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFromBitfield_Invalid(t *testing.T) {
+	for _, tt := range []struct {
+		data []byte
+		n    int
+	}{
+		{[]byte{0xff}, 9},
+		{[]byte{0xff, 0x80}, 8},
+		{[]byte{0xff, 0x40}, 9},
+		{[]byte{0x01}, 7},
+		{nil, 1},
+		{[]byte{}, -1},
+	} {
+		b := new(Bitset)
+		b.Add(3)
+		if err := b.FromBitfield(tt.data, tt.n); err == nil {
+			t.Errorf("b.FromBitfield(%v, %d) succeeded", tt.data, tt.n)
+		}
+		if b.String() != "[3]" {
+			t.Errorf("b.FromBitfield(%v, %d) modified b to %v", tt.data, tt.n, b)
+		}
+	}
+	b := new(Bitset)
+	if err := b.FromBitfield([]byte{0xff, 0x80}, 9); err != nil {
+		t.Error(err)
+	}
+	if b.Count() != 9 {
+		t.Errorf("b.Count() = %d, expected 9", b.Count())
+	}
+}
+
+func ExampleIterator() {
+	s := new(Bitset)
+	s.Add(2)
+	s.Add(42)
+	s.Add(13)
+	for it := s.Iterator(); it.Next(); {
+		fmt.Println(it.Value())
+	}
+	// Output:
+	// 2
+	// 13
+	// 42
+}
+
+func fromInts(l ascendingInts) *Bitset {
+	b := new(Bitset)
+	for _, i := range l {
+		b.Add(int(i))
+	}
+	return b
+}
+
+// checkOp checks that op(b0, b1) contains exactly the integers i for which want(b0.Get(i), b1.Get(i)) is true.
+func checkOp(t *testing.T, name string, op func(b0, b1 *Bitset), want func(in0, in1 bool) bool) {
+	f := func(l0, l1 ascendingInts) bool {
+		b0, b1 := fromInts(l0), fromInts(l1)
+		bx := b0.Copy()
+		op(bx, b1)
+		for i := 0; i < (len(b0.s)+len(b1.s)+1)*64; i++ {
+			if w := want(b0.Get(i), b1.Get(i)); bx.Get(i) != w {
+				t.Logf("%s: bx.Get(%d) = %v, expected %v", name, i, bx.Get(i), w)
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOr(t *testing.T) {
+	checkOp(t, "Or", (*Bitset).Or, func(in0, in1 bool) bool { return in0 || in1 })
+}
+
+func TestXor(t *testing.T) {
+	checkOp(t, "Xor", (*Bitset).Xor, func(in0, in1 bool) bool { return in0 != in1 })
+}
+
+func TestEqual(t *testing.T) {
+	f := func(l0, l1 ascendingInts) bool {
+		b0, b1 := fromInts(l0), fromInts(l1)
+		b2 := b0.Copy()
+		b2.Add(b0.Max() + 1000)
+		b2.Remove(b0.Max() + 1000)
+		if !b0.Equal(b2) || !b2.Equal(b0) {
+			t.Logf("%v is not equal to a copy of itself with trailing zero words", b0)
+			return false
+		}
+		if want := reflect.DeepEqual(l0, l1) || len(l0)+len(l1) == 0; b0.Equal(b1) != want {
+			t.Logf("%v.Equal(%v) = %v, expected %v", b0, b1, !want, want)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIsSubset(t *testing.T) {
+	f := func(l0, l1 ascendingInts) bool {
+		b0, b1 := fromInts(l0), fromInts(l1)
+		want := true
+		for _, i := range l0 {
+			if !b1.Get(i) {
+				want = false
+			}
+		}
+		if b0.IsSubset(b1) != want {
+			t.Logf("%v.IsSubset(%v) = %v, expected %v", b0, b1, !want, want)
+			return false
+		}
+		b1.Or(b0)
+		if !b0.IsSubset(b1) {
+			t.Logf("%v is not a subset of %v", b0, b1)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestComplement(t *testing.T) {
+	f := func(l ascendingInts, n uint16) bool {
+		b := fromInts(l)
+		bx := b.Copy()
+		bx.Complement(int(n))
+		for i := 0; i < int(n)+2*64; i++ {
+			if want := i < int(n) && !b.Get(i); bx.Get(i) != want {
+				t.Logf("bx.Get(%d) = %v, expected %v", i, bx.Get(i), want)
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRange(t *testing.T) {
+	f := func(l ascendingInts, i, j uint16) bool {
+		b := fromInts(l)
+		add, remove := b.Copy(), b.Copy()
+		add.AddRange(int(i), int(j))
+		remove.RemoveRange(int(i), int(j))
+		for k := 0; k < int(j)+len(b.s)*64+64; k++ {
+			in := k >= int(i) && k < int(j)
+			if want := b.Get(k) || in; add.Get(k) != want {
+				t.Logf("AddRange(%d, %d): Get(%d) = %v, expected %v", i, j, k, add.Get(k), want)
+				return false
+			}
+			if want := b.Get(k) && !in; remove.Get(k) != want {
+				t.Logf("RemoveRange(%d, %d): Get(%d) = %v, expected %v", i, j, k, remove.Get(k), want)
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNextClear(t *testing.T) {
+	f := func(l ascendingInts, i uint16) bool {
+		b := fromInts(l)
+		b.AddRange(0, 70)
+		want := int(i)
+		for b.Get(want) {
+			want++
+		}
+		if got := b.NextClear(int(i)); got != want {
+			t.Logf("b.NextClear(%d) = %d, expected %d", i, got, want)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRankSelect(t *testing.T) {
+	f := func(l ascendingInts) bool {
+		b := fromInts(l)
+		for k, i := range l {
+			if r := b.Rank(i); r != k {
+				t.Logf("b.Rank(%d) = %d, expected %d", i, r, k)
+				return false
+			}
+			if r := b.Rank(i + 1); r != k+1 {
+				t.Logf("b.Rank(%d) = %d, expected %d", i+1, r, k+1)
+				return false
+			}
+			if s := b.Select(k); s != i {
+				t.Logf("b.Select(%d) = %d, expected %d", k, s, i)
+				return false
+			}
+		}
+		if s := b.Select(len(l)); s != -1 {
+			t.Logf("b.Select(%d) = %d, expected -1", len(l), s)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIterator(t *testing.T) {
+	f := func(l ascendingInts) bool {
+		b := fromInts(l)
+		var n int
+		for it := b.Iterator(); it.Next(); n++ {
+			if n >= len(l) || it.Value() != l[n] {
+				t.Logf("iteration %d returned %d, expected %v", n, it.Value(), l)
+				return false
+			}
+		}
+		return n == len(l)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
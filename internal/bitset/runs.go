@@ -0,0 +1,172 @@
+package bitset
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Set is the interface implemented by the set representations in this package.
+type Set interface {
+	// Add adds the integer i to the set.
+	Add(i int)
+	// Remove removes the integer i from the set, or does nothing if i is not already in the set.
+	Remove(i int)
+	// Get returns whether i is in the set.
+	Get(i int) bool
+	// Count returns the number of integers in the set.
+	Count() int
+	// Max returns the value of the maximum integer in the set, or -1 if the set is empty.
+	Max() int
+	// NextAfter returns the smallest integer in the set greater than or equal to i or -1 if no such integer exists.
+	NextAfter(i int) int
+}
+
+var (
+	_ Set = (*Bitset)(nil)
+	_ Set = (*Runs)(nil)
+)
+
+// Runs represents a set of non-negative integers as a sorted list of runs of consecutive integers. It uses far less
+// memory than a Bitset when the set is mostly full or mostly empty, at the cost of O(log r) lookups and O(r)
+// insertions, where r is the number of runs.
+type Runs struct {
+	r []run
+	n int
+}
+
+// run is the range of integers [start, end).
+type run struct {
+	start, end int
+}
+
+// Compress returns a Runs containing the integers in s.
+func Compress(s *Bitset) *Runs {
+	rs := new(Runs)
+	for i := s.NextAfter(0); i >= 0; {
+		j := s.NextClear(i)
+		rs.appendRun(i, j)
+		i = s.NextAfter(j)
+	}
+	return rs
+}
+
+// Bitset returns a Bitset containing the integers in s.
+func (s *Runs) Bitset() *Bitset {
+	b := new(Bitset)
+	for _, r := range s.r {
+		b.AddRange(r.start, r.end)
+	}
+	return b
+}
+
+func (s *Runs) appendRun(start, end int) {
+	s.r = append(s.r, run{start, end})
+	s.n += end - start
+}
+
+// search returns the index of the first run which ends after i, or len(s.r) if there is none.
+func (s *Runs) search(i int) int {
+	return sort.Search(len(s.r), func(j int) bool { return s.r[j].end > i })
+}
+
+// Add adds the integer i to s.
+func (s *Runs) Add(i int) {
+	if i < 0 {
+		panic("bitset: cannot add non-negative integer to set")
+	}
+	j := s.search(i)
+	if j < len(s.r) && s.r[j].start <= i {
+		return
+	}
+	s.n++
+	joinPrev := j > 0 && s.r[j-1].end == i
+	joinNext := j < len(s.r) && s.r[j].start == i+1
+	switch {
+	case joinPrev && joinNext:
+		s.r[j-1].end = s.r[j].end
+		s.r = append(s.r[:j], s.r[j+1:]...)
+	case joinPrev:
+		s.r[j-1].end++
+	case joinNext:
+		s.r[j].start--
+	default:
+		s.r = append(s.r, run{})
+		copy(s.r[j+1:], s.r[j:])
+		s.r[j] = run{i, i + 1}
+	}
+}
+
+// Remove removes the integer i from s, or does nothing if i is not already in s.
+func (s *Runs) Remove(i int) {
+	j := s.search(i)
+	if i < 0 || j == len(s.r) || s.r[j].start > i {
+		return
+	}
+	s.n--
+	r := s.r[j]
+	switch {
+	case r.start == i && r.end == i+1:
+		s.r = append(s.r[:j], s.r[j+1:]...)
+	case r.start == i:
+		s.r[j].start++
+	case r.end == i+1:
+		s.r[j].end--
+	default:
+		s.r = append(s.r, run{})
+		copy(s.r[j+1:], s.r[j:])
+		s.r[j].end = i
+		s.r[j+1].start = i + 1
+	}
+}
+
+// Get returns whether i is in s.
+func (s *Runs) Get(i int) bool {
+	j := s.search(i)
+	return i >= 0 && j < len(s.r) && s.r[j].start <= i
+}
+
+// Count returns the number of integers in s.
+func (s *Runs) Count() int {
+	return s.n
+}
+
+// Max returns the value of the maximum integer in s, or -1 if s is empty.
+func (s *Runs) Max() int {
+	if len(s.r) == 0 {
+		return -1
+	}
+	return s.r[len(s.r)-1].end - 1
+}
+
+// NextAfter returns the smallest integer in s greater than or equal to i or -1 if no such integer exists.
+func (s *Runs) NextAfter(i int) int {
+	if i < 0 {
+		i = 0
+	}
+	j := s.search(i)
+	if j == len(s.r) {
+		return -1
+	}
+	if s.r[j].start > i {
+		return s.r[j].start
+	}
+	return i
+}
+
+// String returns a string representation of s.
+func (s *Runs) String() string {
+	var buf bytes.Buffer
+	buf.WriteRune('[')
+	for j, r := range s.r {
+		if j > 0 {
+			buf.WriteRune(' ')
+		}
+		fmt.Fprintf(&buf, "%d", r.start)
+		if r.end-r.start > 1 {
+			fmt.Fprintf(&buf, "-%d", r.end-1)
+		}
+	}
+	buf.WriteRune(']')
+	return buf.String()
+}
@@ -0,0 +1,137 @@
+package bitset
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"testing/quick"
+	"unsafe"
+)
+
+func ExampleRuns_String() {
+	s := new(Runs)
+	for _, i := range []int{2, 3, 4, 13, 42, 43} {
+		s.Add(i)
+	}
+	fmt.Println(s)
+	// Output: [2-4 13 42-43]
+}
+
+func TestRuns(t *testing.T) {
+	f := func(ops []int16) bool {
+		b := new(Bitset)
+		rs := new(Runs)
+		for _, op := range ops {
+			// Restrict to a small range so that runs form and merge.
+			i := int(op) % 200
+			if i < 0 {
+				rs.Remove(-i)
+				b.Remove(-i)
+			} else {
+				rs.Add(i)
+				b.Add(i)
+			}
+		}
+		for i := -1; i < 202; i++ {
+			if rs.Get(i) != b.Get(i) {
+				t.Logf("rs.Get(%d) = %v, expected %v", i, rs.Get(i), b.Get(i))
+				return false
+			}
+			if rs.NextAfter(i) != b.NextAfter(i) {
+				t.Logf("rs.NextAfter(%d) = %d, expected %d", i, rs.NextAfter(i), b.NextAfter(i))
+				return false
+			}
+		}
+		if rs.Count() != b.Count() || rs.Max() != b.Max() {
+			t.Logf("rs.Count(), rs.Max() = %d, %d, expected %d, %d", rs.Count(), rs.Max(), b.Count(), b.Max())
+			return false
+		}
+		for j := 1; j < len(rs.r); j++ {
+			if rs.r[j-1].end >= rs.r[j].start {
+				t.Logf("runs %v and %v are not separate", rs.r[j-1], rs.r[j])
+				return false
+			}
+		}
+		if !rs.Bitset().Equal(b) {
+			t.Logf("rs.Bitset() = %v, expected %v", rs.Bitset(), b)
+			return false
+		}
+		if c := Compress(b); c.String() != rs.String() || c.Count() != rs.Count() {
+			t.Logf("Compress(b) = %v, expected %v", c, rs)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	f := func(l ascendingInts, i, j uint16) bool {
+		b := fromInts(l)
+		b.AddRange(int(i), int(j))
+		if rs := Compress(b); !rs.Bitset().Equal(b) {
+			t.Logf("Compress(%v) = %v", b, rs)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// benchmarkSet adds every integer in [0, n) to s with probability p, then reports the memory used.
+func benchmarkSet(b *testing.B, newSet func() Set, size func(Set) int, p float64) {
+	const n = 1 << 20
+	rng := rand.New(rand.NewSource(1))
+	var l []int
+	for i := 0; i < n; i++ {
+		if rng.Float64() < p {
+			l = append(l, i)
+		}
+	}
+	b.ResetTimer()
+	var s Set
+	for k := 0; k < b.N; k++ {
+		s = newSet()
+		for _, i := range l {
+			s.Add(i)
+		}
+		for i := 0; i < n; i += 97 {
+			s.Get(i)
+		}
+	}
+	b.ReportMetric(float64(size(s)), "set-bytes")
+}
+
+func bitsetSize(s Set) int {
+	return cap(s.(*Bitset).s) * int(unsafe.Sizeof(uint(0)))
+}
+
+func runsSize(s Set) int {
+	return cap(s.(*Runs).r) * int(unsafe.Sizeof(run{}))
+}
+
+func newBitset() Set { return new(Bitset) }
+func newRuns() Set   { return new(Runs) }
+
+func BenchmarkBitset_MostlyEmpty(b *testing.B) { benchmarkSet(b, newBitset, bitsetSize, 0.001) }
+func BenchmarkRuns_MostlyEmpty(b *testing.B)   { benchmarkSet(b, newRuns, runsSize, 0.001) }
+func BenchmarkBitset_MostlyFull(b *testing.B)  { benchmarkSet(b, newBitset, bitsetSize, 0.999) }
+func BenchmarkRuns_MostlyFull(b *testing.B)    { benchmarkSet(b, newRuns, runsSize, 0.999) }
+
+func BenchmarkCompress_MostlyFull(b *testing.B) {
+	const n = 1 << 20
+	s := new(Bitset)
+	s.AddRange(0, n)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < n/1000; i++ {
+		s.Remove(rng.Intn(n))
+	}
+	b.ResetTimer()
+	for k := 0; k < b.N; k++ {
+		Compress(s)
+	}
+}
@@ -0,0 +1,198 @@
+package iox
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket which limits the rate of a transfer in bytes per second. A Limiter may have a parent, in
+// which case every transfer must also be permitted by the parent. This allows limits to be arranged in a hierarchy,
+// such as global, per-torrent and per-connection. A Limiter is safe for concurrent use.
+type Limiter struct {
+	parent *Limiter
+	mu     sync.Mutex
+	rate   float64 // bytes per second, or <= 0 for unlimited
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a Limiter which allows rate bytes per second, in bursts of up to burst bytes, and whose transfers
+// must also be permitted by parent if it is not nil. A rate <= 0 means unlimited, and a burst <= 0 means one second's
+// worth of bytes.
+func NewLimiter(parent *Limiter, rate float64, burst int) *Limiter {
+	l := &Limiter{parent: parent}
+	l.SetLimit(rate, burst)
+	l.tokens = float64(l.burst)
+	return l
+}
+
+// SetLimit changes the rate and burst of l, with the same meaning as in NewLimiter. Transfers which are already
+// waiting are not affected. A Limiter which was unlimited starts with a full bucket, as a new one does.
+func (l *Limiter) SetLimit(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.advance(time.Now())
+	if burst <= 0 {
+		burst = int(rate)
+	}
+	if l.rate <= 0 && rate > 0 {
+		l.tokens = float64(burst)
+	}
+	l.rate, l.burst = rate, burst
+	if l.tokens > float64(burst) {
+		l.tokens = float64(burst)
+	}
+}
+
+// Limit returns the rate and burst of l.
+func (l *Limiter) Limit() (rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate, l.burst
+}
+
+// WaitN blocks until l and all of its parents permit a transfer of n bytes, or ctx is done.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var delay time.Duration
+	var reserved []*Limiter
+	for ll := l; ll != nil; ll = ll.parent {
+		d, ok := ll.reserve(n)
+		if !ok {
+			continue
+		}
+		reserved = append(reserved, ll)
+		if d > delay {
+			delay = d
+		}
+	}
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		for _, ll := range reserved {
+			ll.refund(n)
+		}
+		return ctx.Err()
+	}
+}
+
+// chunk returns the largest transfer which should be made at once through l and its parents, or 0 if they are all
+// unlimited.
+func (l *Limiter) chunk() int {
+	var c int
+	for ; l != nil; l = l.parent {
+		rate, burst := l.Limit()
+		if rate > 0 && burst > 0 && (c == 0 || burst < c) {
+			c = burst
+		}
+	}
+	return c
+}
+
+// reserve takes n tokens from the bucket, returning how long to wait until they are available. ok is false if l is
+// unlimited.
+func (l *Limiter) reserve(n int) (d time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0, false
+	}
+	l.advance(time.Now())
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0, true
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second)), true
+}
+
+func (l *Limiter) refund(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens += float64(n)
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+}
+
+// advance fills the bucket with the tokens accumulated since it was last advanced.
+func (l *Limiter) advance(now time.Time) {
+	if !l.last.IsZero() && l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > float64(l.burst) {
+			l.tokens = float64(l.burst)
+		}
+	}
+	l.last = now
+}
+
+type limitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *Limiter
+}
+
+// NewLimitedReader returns a Reader which reads from r no faster than l permits. Reads block until l permits them,
+// and return ctx.Err() if ctx is done first.
+func NewLimitedReader(ctx context.Context, r io.Reader, l *Limiter) io.Reader {
+	return &limitedReader{ctx, r, l}
+}
+
+func (r *limitedReader) Read(p []byte) (n int, err error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if c := r.l.chunk(); c > 0 && len(p) > c {
+		p = p[:c]
+	}
+	n, err = r.r.Read(p)
+	if n > 0 {
+		if err0 := r.l.WaitN(r.ctx, n); err == nil {
+			err = err0
+		}
+	}
+	return
+}
+
+type limitedWriter struct {
+	ctx context.Context
+	w   io.Writer
+	l   *Limiter
+}
+
+// NewLimitedWriter returns a Writer which writes to w no faster than l permits. Writes block until l permits them,
+// and return ctx.Err() if ctx is done first.
+func NewLimitedWriter(ctx context.Context, w io.Writer, l *Limiter) io.Writer {
+	return &limitedWriter{ctx, w, l}
+}
+
+func (w *limitedWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		q := p
+		if c := w.l.chunk(); c > 0 && len(q) > c {
+			q = q[:c]
+		}
+		err = w.l.WaitN(w.ctx, len(q))
+		if err != nil {
+			return
+		}
+		var n0 int
+		n0, err = w.w.Write(q)
+		n += n0
+		if err != nil {
+			return
+		}
+		p = p[n0:]
+	}
+	return
+}
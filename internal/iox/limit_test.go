@@ -0,0 +1,100 @@
+package iox
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestLimitedWriter(t *testing.T) {
+	global := NewLimiter(nil, 20000, 1000)
+	conn := NewLimiter(global, 0, 0)
+	var buf bytes.Buffer
+	w := NewLimitedWriter(context.Background(), &buf, conn)
+	start := time.Now()
+	// The first 1000 bytes are allowed by the burst, the remaining 4000 take 200ms.
+	n, err := w.Write(make([]byte, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5000 || buf.Len() != 5000 {
+		t.Errorf("wrote %d bytes, buffer has %d, expected 5000", n, buf.Len())
+	}
+	if d := time.Since(start); d < 150*time.Millisecond || d > time.Second {
+		t.Errorf("write took %v, expected about 200ms", d)
+	}
+}
+
+func TestLimitedReader(t *testing.T) {
+	torrent := NewLimiter(nil, 0, 0)
+	conn := NewLimiter(torrent, 0, 0)
+	r := NewLimitedReader(context.Background(), io.LimitReader(zeroReader{}, 5000), conn)
+	start := time.Now()
+	_, err := io.Copy(ioutil.Discard, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("unlimited read took %v", d)
+	}
+	torrent.SetLimit(20000, 1000)
+	r = NewLimitedReader(context.Background(), io.LimitReader(zeroReader{}, 5000), conn)
+	start = time.Now()
+	_, err = io.Copy(ioutil.Discard, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 150*time.Millisecond || d > time.Second {
+		t.Errorf("read took %v, expected about 200ms", d)
+	}
+}
+
+func TestLimiter_SetLimit(t *testing.T) {
+	l := NewLimiter(nil, 0, 0)
+	l.SetLimit(1000, 1000)
+	// The burst is available at once, as it is from a new Limiter.
+	start := time.Now()
+	if err := l.WaitN(context.Background(), 1000); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("first wait after limiting took %v, expected none", d)
+	}
+	// Limiting an already limited Limiter again does not refill it.
+	l.SetLimit(2000, 1000)
+	if d, _ := l.reserve(200); d < 50*time.Millisecond {
+		t.Errorf("reserve after changing limit waits %v, expected about 100ms", d)
+	}
+}
+
+func TestLimiter_Cancel(t *testing.T) {
+	l := NewLimiter(nil, 1000, 1000)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := l.WaitN(ctx, 100000); err != context.DeadlineExceeded {
+		t.Errorf("l.WaitN() = %v, expected %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("cancelled wait took %v", d)
+	}
+	// The tokens of the cancelled wait are returned, so the burst is available again.
+	if err := l.WaitN(context.Background(), 500); err != nil {
+		t.Error(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("wait after cancellation took %v", d)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,314 @@
+package iox
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// meterTick is how often a Meter updates its averages.
+const meterTick = time.Second
+
+// DefaultWindows are the windows of a Meter when none are given.
+var DefaultWindows = []time.Duration{5 * time.Second, time.Minute, 15 * time.Minute}
+
+// Meter measures the rate of a transfer as exponentially weighted moving averages over several windows, along with
+// the peak of each average and the total transferred. A Meter may have a parent, to which everything it measures is
+// also added. A Meter is safe for concurrent use.
+type Meter struct {
+	parent  *Meter
+	clock   Clock
+	windows []time.Duration
+	mu      sync.Mutex
+	rates   []float64
+	peaks   []float64
+	total   int64
+	pending int64 // bytes since the last tick
+	last    time.Time
+}
+
+// NewMeter returns a Meter which averages over windows, or DefaultWindows if none are given, and adds everything
+// it measures to parent if it is not nil.
+func NewMeter(parent *Meter, windows ...time.Duration) *Meter {
+	if len(windows) == 0 {
+		windows = DefaultWindows
+	}
+	m := &Meter{
+		parent:  parent,
+		clock:   SystemClock,
+		windows: windows,
+		rates:   make([]float64, len(windows)),
+		peaks:   make([]float64, len(windows)),
+	}
+	if parent != nil {
+		m.clock = parent.clock
+	}
+	m.last = m.clock.Now()
+	return m
+}
+
+// Mark records the transfer of n bytes.
+func (m *Meter) Mark(n int) {
+	for ; m != nil; m = m.parent {
+		m.mu.Lock()
+		m.tick()
+		m.pending += int64(n)
+		m.total += int64(n)
+		m.mu.Unlock()
+	}
+}
+
+// Windows returns the windows m averages over.
+func (m *Meter) Windows() []time.Duration {
+	return m.windows
+}
+
+// Rates returns the average rate in bytes per second over each of the windows of m.
+func (m *Meter) Rates() []float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tick()
+	return append([]float64(nil), m.rates...)
+}
+
+// Peaks returns the highest average rate in bytes per second seen over each of the windows of m.
+func (m *Meter) Peaks() []float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tick()
+	return append([]float64(nil), m.peaks...)
+}
+
+// Total returns the total number of bytes transferred.
+func (m *Meter) Total() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.total
+}
+
+// tick updates the averages for every tick which has passed since the last update.
+func (m *Meter) tick() {
+	now := m.clock.Now()
+	ticks := int64(now.Sub(m.last) / meterTick)
+	if ticks <= 0 {
+		return
+	}
+	m.last = m.last.Add(time.Duration(ticks) * meterTick)
+	// The pending bytes were transferred during the first tick, and nothing during the rest.
+	x := float64(m.pending) / meterTick.Seconds()
+	m.pending = 0
+	for i, w := range m.windows {
+		decay := math.Exp(-meterTick.Seconds() / w.Seconds())
+		m.rates[i] = x + decay*(m.rates[i]-x)
+		if m.rates[i] > m.peaks[i] {
+			m.peaks[i] = m.rates[i]
+		}
+		m.rates[i] *= math.Pow(decay, float64(ticks-1))
+	}
+}
+
+type meterReader struct {
+	r io.Reader
+	m *Meter
+}
+
+// NewMeterReader returns a Reader which reads from r, recording the bytes read in m.
+func NewMeterReader(r io.Reader, m *Meter) io.Reader {
+	return &meterReader{r, m}
+}
+
+func (r *meterReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	r.m.Mark(n)
+	return
+}
+
+type meterWriter struct {
+	w io.Writer
+	m *Meter
+}
+
+// NewMeterWriter returns a Writer which writes to w, recording the bytes written in m.
+func NewMeterWriter(w io.Writer, m *Meter) io.Writer {
+	return &meterWriter{w, m}
+}
+
+func (w *meterWriter) Write(p []byte) (n int, err error) {
+	n, err = w.w.Write(p)
+	w.m.Mark(n)
+	return
+}
+
+// Registry holds a hierarchy of named Meters, so that stats can be aggregated, for example, per peer, per torrent and
+// globally. A Registry is safe for concurrent use.
+type Registry struct {
+	windows []time.Duration
+	clock   Clock
+	mu      sync.Mutex
+	meters  map[string]*Meter
+	saved   map[string]savedMeter // loaded stats of meters which have not yet been created
+}
+
+// NewRegistry returns a Registry whose Meters average over windows, or DefaultWindows if none are given.
+func NewRegistry(windows ...time.Duration) *Registry {
+	return &Registry{
+		windows: windows,
+		clock:   SystemClock,
+		meters:  make(map[string]*Meter),
+		saved:   make(map[string]savedMeter),
+	}
+}
+
+// Meter returns the Meter with the given path, creating it if it does not exist. Its parent is the Meter whose path
+// has the last element removed, and the root Meter, with an empty path, measures everything in the registry. For
+// example r.Meter() might be global, r.Meter(infohash) per torrent and r.Meter(infohash, peer) per peer.
+//
+// In the names of Meters reported by Stats the elements of the path are joined by /, with any / or % in them
+// escaped as %2F or %25, so that different paths always have different names.
+func (r *Registry) Meter(path ...string) *Meter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.meter(path)
+}
+
+// meterName returns the name of the Meter with the given path.
+func meterName(path []string) string {
+	escaped := make([]string, len(path))
+	for i, p := range path {
+		escaped[i] = nameEscaper.Replace(p)
+	}
+	return strings.Join(escaped, "/")
+}
+
+var nameEscaper = strings.NewReplacer("%", "%25", "/", "%2F")
+
+func (r *Registry) meter(path []string) *Meter {
+	name := meterName(path)
+	if m, ok := r.meters[name]; ok {
+		return m
+	}
+	var parent *Meter
+	if len(path) > 0 {
+		parent = r.meter(path[:len(path)-1])
+	}
+	m := NewMeter(parent, r.windows...)
+	m.clock = r.clock
+	m.last = r.clock.Now()
+	if s, ok := r.saved[name]; ok {
+		s.restore(m)
+		delete(r.saved, name)
+	}
+	r.meters[name] = m
+	return m
+}
+
+// Remove removes the Meter with the given path and all of its descendants from r. Their totals remain in their
+// ancestors.
+func (r *Registry) Remove(path ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name := meterName(path)
+	for k := range r.meters {
+		if k == name || strings.HasPrefix(k, name+"/") || name == "" {
+			delete(r.meters, k)
+		}
+	}
+}
+
+// MeterStats is a report of the stats of a Meter.
+type MeterStats struct {
+	Name  string    `json:"name"`
+	Total int64     `json:"total"`
+	Rates []float64 `json:"rates"`
+	Peaks []float64 `json:"peaks"`
+}
+
+// Stats returns the stats of every Meter in r, sorted by name.
+func (r *Registry) Stats() []MeterStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := make([]MeterStats, 0, len(r.meters))
+	for name, m := range r.meters {
+		stats = append(stats, MeterStats{
+			Name:  name,
+			Total: m.Total(),
+			Rates: m.Rates(),
+			Peaks: m.Peaks(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+type savedMeter struct {
+	Total int64     `json:"total"`
+	Peaks []float64 `json:"peaks"`
+}
+
+// restore adds the total of s to that of m, and raises the peaks of m to those of s.
+func (s savedMeter) restore(m *Meter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.total += s.Total
+	if len(s.Peaks) == len(m.peaks) {
+		for i, p := range s.Peaks {
+			m.peaks[i] = math.Max(m.peaks[i], p)
+		}
+	}
+}
+
+// add returns the combination of s and t, as restoring both to a Meter would.
+func (s savedMeter) add(t savedMeter) savedMeter {
+	s.Total += t.Total
+	if len(s.Peaks) != len(t.Peaks) {
+		return s
+	}
+	peaks := make([]float64, len(s.Peaks))
+	for i := range peaks {
+		peaks[i] = math.Max(s.Peaks[i], t.Peaks[i])
+	}
+	s.Peaks = peaks
+	return s
+}
+
+// Save writes the totals and peaks of every Meter in r to w as JSON, so that they can be restored by Load.
+func (r *Registry) Save(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	saved := make(map[string]savedMeter, len(r.meters)+len(r.saved))
+	for name, s := range r.saved {
+		saved[name] = s
+	}
+	for name, m := range r.meters {
+		saved[name] = savedMeter{m.Total(), m.Peaks()}
+	}
+	return errors.Wrap(json.NewEncoder(w).Encode(saved), "saving stats")
+}
+
+// Load restores the totals and peaks written by Save. The saved totals are added to those measured so far, and the
+// peaks kept if they are higher. Meters which do not exist yet are restored when they are created.
+func (r *Registry) Load(rd io.Reader) error {
+	var saved map[string]savedMeter
+	err := json.NewDecoder(rd).Decode(&saved)
+	if err != nil {
+		return errors.Wrap(err, "loading stats")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, s := range saved {
+		if m, ok := r.meters[name]; ok {
+			s.restore(m)
+		} else if prev, ok := r.saved[name]; ok {
+			r.saved[name] = prev.add(s)
+		} else {
+			r.saved[name] = s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package iox
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math"
+	"testing"
+	"time"
+)
+
+type manualClock struct {
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	return c.now
+}
+
+func (c *manualClock) After(d time.Duration) <-chan time.Time {
+	panic("not implemented")
+}
+
+func TestMeter(t *testing.T) {
+	c := &manualClock{now: time.Unix(0, 0)}
+	r := NewRegistry(5*time.Second, time.Minute)
+	r.clock = c
+	peer := r.Meter("torrent", "peer")
+	// A steady 1000 bytes per second for long enough for both averages to converge.
+	for i := 0; i < 600; i++ {
+		_, err := io.Copy(ioutil.Discard, NewMeterReader(io.LimitReader(zeroReader{}, 1000), peer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.now = c.now.Add(time.Second)
+	}
+	for _, path := range [][]string{nil, {"torrent"}, {"torrent", "peer"}} {
+		m := r.Meter(path...)
+		if total := m.Total(); total != 600000 {
+			t.Errorf("%q: total = %d, expected 600000", path, total)
+		}
+		for i, rate := range m.Rates() {
+			if math.Abs(rate-1000) > 1 {
+				t.Errorf("%q: rate over %v = %v, expected 1000", path, m.Windows()[i], rate)
+			}
+		}
+	}
+	// After 10 idle seconds the short average has mostly decayed, but the peaks remain.
+	c.now = c.now.Add(10 * time.Second)
+	rates, peaks := peer.Rates(), peer.Peaks()
+	if rates[0] > 200 || rates[1] < 800 {
+		t.Errorf("rates after idling = %v", rates)
+	}
+	for i := range peaks {
+		if math.Abs(peaks[i]-1000) > 1 {
+			t.Errorf("peak over %v = %v, expected 1000", peer.Windows()[i], peaks[i])
+		}
+	}
+}
+
+func TestRegistry_SaveLoad(t *testing.T) {
+	r := NewRegistry()
+	w := NewMeterWriter(ioutil.Discard, r.Meter("a", "b"))
+	w.Write(make([]byte, 100))
+	r.Remove("a", "b")
+	var buf bytes.Buffer
+	err := r.Save(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = NewRegistry()
+	r.Meter("a")
+	err = r.Load(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	NewMeterWriter(ioutil.Discard, r.Meter("a", "c")).Write(make([]byte, 10))
+	want := map[string]int64{"": 110, "a": 110, "a/c": 10}
+	stats := r.Stats()
+	if len(stats) != len(want) {
+		t.Errorf("r.Stats() = %+v, expected %d meters", stats, len(want))
+	}
+	for _, s := range stats {
+		if s.Total != want[s.Name] {
+			t.Errorf("%q: total = %d, expected %d", s.Name, s.Total, want[s.Name])
+		}
+	}
+}
+
+func TestRegistry_LoadAdds(t *testing.T) {
+	r := NewRegistry()
+	r.Meter("a").Mark(100)
+	var buf bytes.Buffer
+	if err := r.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r = NewRegistry()
+	r.Meter("a").Mark(5)
+	if err := r.Load(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Load(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if total := r.Meter("a").Total(); total != 205 {
+		t.Errorf("total after loading twice = %d, expected 205", total)
+	}
+}
+
+func TestRegistry_Names(t *testing.T) {
+	r := NewRegistry()
+	r.Meter("a/b", "c").Mark(1)
+	r.Meter("a", "b/c").Mark(2)
+	r.Meter("a%2Fb").Mark(4)
+	want := map[string]int64{
+		"":        7,
+		"a%2Fb":   1,
+		"a%2Fb/c": 1,
+		"a":       2,
+		"a/b%2Fc": 2,
+		"a%252Fb": 4,
+	}
+	stats := r.Stats()
+	if len(stats) != len(want) {
+		t.Errorf("r.Stats() = %+v, expected %d meters", stats, len(want))
+	}
+	for _, s := range stats {
+		if s.Total != want[s.Name] {
+			t.Errorf("%q: total = %d, expected %d", s.Name, s.Total, want[s.Name])
+		}
+	}
+}
+
+func TestStatWriter(t *testing.T) {
+	w := NewStatWriter(ioutil.Discard, time.Second)
+	w.Write(make([]byte, 100))
+	w.Write(make([]byte, 23))
+	if total := w.Total(); total != 123 {
+		t.Errorf("w.Total() = %d, expected 123", total)
+	}
+}
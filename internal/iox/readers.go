@@ -9,8 +9,8 @@ import (
 	"time"
 )
 
-type StatReader struct {
-	r             io.Reader
+// stat measures a transfer over a single interval, blending the rates of the current and previous intervals.
+type stat struct {
 	last, current int64
 	total         int64
 	deadline      time.Time
@@ -18,45 +18,56 @@ type StatReader struct {
 	mu            sync.Mutex
 }
 
-func NewStatReader(r io.Reader, interval time.Duration) *StatReader {
-	return &StatReader{
-		r:        r,
-		interval: interval,
-	}
-}
-
-func (r *StatReader) Read(p []byte) (n int, err error) {
-	n, err = r.r.Read(p)
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (s *stat) add(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	now := time.Now()
-	if now.After(r.deadline) {
-		r.deadline = now.Add(r.interval)
-		r.last = r.current
-		r.current = 0
+	if now.After(s.deadline) {
+		s.deadline = now.Add(s.interval)
+		s.last = s.current
+		s.current = 0
 	}
-	r.current += int64(n)
-	r.total += int64(n)
-	return
+	s.current += int64(n)
+	s.total += int64(n)
 }
 
-func (r *StatReader) Rate() float64 {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	interval := r.interval.Seconds()
-	currentRate := float64(r.current) / interval
-	currentWeight := float64(r.interval-time.Until(r.deadline)) / float64(r.interval)
-	lastRate := float64(r.last) / interval
+func (s *stat) Rate() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	interval := s.interval.Seconds()
+	currentRate := float64(s.current) / interval
+	currentWeight := float64(s.interval-time.Until(s.deadline)) / float64(s.interval)
+	lastRate := float64(s.last) / interval
 	lastWeight := 1 - currentWeight
 	return lastRate*lastWeight + currentRate*currentWeight
 }
 
-func (r *StatReader) Total() int64 {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	return r.total
+func (s *stat) Total() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.total
+}
+
+type StatReader struct {
+	r io.Reader
+	stat
+}
+
+func NewStatReader(r io.Reader, interval time.Duration) *StatReader {
+	sr := &StatReader{r: r}
+	sr.interval = interval
+	return sr
+}
+
+func (r *StatReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	r.add(n)
+	return
 }
 
+// ErrChecksum is returned by the reader returned by NewChecksumReader if the data read does not have the expected checksum.
+var ErrChecksum = errors.New("validation failed")
+
 type checksumReader struct {
 	r   io.Reader
 	h   hash.Hash
@@ -74,7 +85,7 @@ func NewChecksumReader(r io.Reader, h hash.Hash, sum []byte) io.Reader {
 func (r *checksumReader) Read(p []byte) (n int, err error) {
 	n, err = r.r.Read(p)
 	if err == io.EOF && bytes.Equal(r.h.Sum(nil), r.sum) == false {
-		err = errors.New("validation failed")
+		err = ErrChecksum
 		return
 	}
 	return
@@ -0,0 +1,251 @@
+package iox
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Clock tells the time and waits for it to pass. It allows time to be faked in tests.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is the real Clock.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Rule is a limit which applies between Start and End, measured from midnight, on the days which are true in Days.
+// If End is before Start the rule continues past midnight into the following day.
+type Rule struct {
+	Days       [7]bool // indexed by time.Weekday
+	Start, End time.Duration
+	Rate       float64 // bytes per second, or <= 0 for unlimited
+}
+
+// contains returns whether r applies at tod, the time since midnight, on weekday day.
+func (r Rule) contains(day time.Weekday, tod time.Duration) bool {
+	if r.Start <= r.End {
+		return r.Days[day] && tod >= r.Start && tod < r.End
+	}
+	return r.Days[day] && tod >= r.Start || r.Days[(day+6)%7] && tod < r.End
+}
+
+// Schedule is a set of alternative limits which apply at different times of the week.
+type Schedule struct {
+	Rules   []Rule  // the first rule which applies takes precedence
+	Default float64 // the rate when no rule applies
+}
+
+// Rate returns the rate which applies at t, in the location of t.
+func (s *Schedule) Rate(t time.Time) float64 {
+	tod := t.Sub(midnight(t, 0))
+	for _, r := range s.Rules {
+		if r.contains(t.Weekday(), tod) {
+			return r.Rate
+		}
+	}
+	return s.Default
+}
+
+// next returns the first time after t at which a rule may start or end, or the zero time if there are no rules.
+func (s *Schedule) next(t time.Time) time.Time {
+	var next time.Time
+	for day := 0; day <= 7; day++ {
+		m := midnight(t, day)
+		wd := m.Weekday()
+		for _, r := range s.Rules {
+			var bounds []time.Time
+			if r.Days[wd] {
+				bounds = append(bounds, m.Add(r.Start))
+			}
+			if r.Start <= r.End && r.Days[wd] || r.End < r.Start && r.Days[(wd+6)%7] {
+				bounds = append(bounds, m.Add(r.End))
+			}
+			for _, b := range bounds {
+				if b.After(t) && (next.IsZero() || b.Before(next)) {
+					next = b
+				}
+			}
+		}
+	}
+	return next
+}
+
+func midnight(t time.Time, days int) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+days, 0, 0, 0, 0, t.Location())
+}
+
+// Run sets the rate of l according to s, as measured by c, until ctx is done. The burst of l is left as one
+// second's worth of bytes.
+func (s *Schedule) Run(ctx context.Context, l *Limiter, c Clock) error {
+	for {
+		now := c.Now()
+		l.SetLimit(s.Rate(now), 0)
+		var wait <-chan time.Time
+		if next := s.next(now); !next.IsZero() {
+			wait = c.After(next.Sub(now))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wait:
+		}
+	}
+}
+
+// ParseSchedule parses a schedule with one rule per line, in the form
+//
+//	days start-end rate
+//
+// where days is a comma separated list of days or ranges of days, such as Mon-Fri,Sun, or * for every day, start
+// and end are times of day such as 09:00 and 18:00, and rate is a number of bytes per second with an optional
+// suffix such as 1MB/s or 512KiB/s, or unlimited. An end of 24:00 means midnight at the end of the day. The rate
+// when no rule applies is given by a line of the form
+//
+//	default rate
+//
+// Blank lines and lines starting with # are ignored.
+func ParseSchedule(r io.Reader) (*Schedule, error) {
+	s := new(Schedule)
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var err error
+		switch {
+		case len(fields) == 2 && fields[0] == "default":
+			s.Default, err = ParseRate(fields[1])
+		case len(fields) == 3:
+			var rule Rule
+			rule, err = parseRule(fields)
+			s.Rules = append(s.Rules, rule)
+		default:
+			err = errors.New("expected days, times and rate")
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "schedule line %d", line)
+		}
+	}
+	return s, errors.Wrap(sc.Err(), "reading schedule")
+}
+
+func parseRule(fields []string) (r Rule, err error) {
+	r.Days, err = parseDays(fields[0])
+	if err != nil {
+		return
+	}
+	times := strings.Split(fields[1], "-")
+	if len(times) != 2 {
+		return r, errors.Errorf("invalid times %q", fields[1])
+	}
+	r.Start, err = parseTimeOfDay(times[0])
+	if err != nil {
+		return
+	}
+	r.End, err = parseTimeOfDay(times[1])
+	if err != nil {
+		return
+	}
+	r.Rate, err = ParseRate(fields[2])
+	return
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func parseDays(s string) (days [7]bool, err error) {
+	if s == "*" {
+		return [7]bool{true, true, true, true, true, true, true}, nil
+	}
+	for _, d := range strings.Split(s, ",") {
+		r := strings.SplitN(d, "-", 2)
+		first, ok := weekdays[strings.ToLower(r[0])]
+		if !ok {
+			return days, errors.Errorf("invalid day %q", r[0])
+		}
+		last := first
+		if len(r) == 2 {
+			last, ok = weekdays[strings.ToLower(r[1])]
+			if !ok {
+				return days, errors.Errorf("invalid day %q", r[1])
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	if s == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, errors.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+var rateSuffixes = []struct {
+	suffix string
+	scale  float64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"KB", 1e3},
+	{"MB", 1e6},
+	{"GB", 1e9},
+	{"B", 1},
+}
+
+// ParseRate parses a rate in bytes per second such as 1MB/s, 512KiB or 1000, returning 0 for unlimited.
+func ParseRate(s string) (float64, error) {
+	if s == "unlimited" {
+		return 0, nil
+	}
+	num := strings.TrimSuffix(s, "/s")
+	scale := 1.0
+	for _, x := range rateSuffixes {
+		if strings.HasSuffix(num, x.suffix) {
+			num, scale = strings.TrimSuffix(num, x.suffix), x.scale
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f <= 0 {
+		return 0, errors.Errorf("invalid rate %q", s)
+	}
+	return f * scale, nil
+}
@@ -0,0 +1,115 @@
+package iox
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSchedule = `
+# Office hours
+Mon-Fri 09:00-18:00 1MB/s
+Fri,Sat 22:00-06:00 512KiB
+default unlimited
+`
+
+func TestSchedule_Rate(t *testing.T) {
+	s, err := ParseSchedule(strings.NewReader(testSchedule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		t    string
+		rate float64
+	}{
+		{"2017-09-04 08:59", 0}, // Monday
+		{"2017-09-04 09:00", 1e6},
+		{"2017-09-04 17:59", 1e6},
+		{"2017-09-04 18:00", 0},
+		{"2017-09-08 23:00", 512 << 10}, // Friday
+		{"2017-09-09 05:59", 512 << 10},
+		{"2017-09-09 12:00", 0},
+		{"2017-09-10 03:00", 512 << 10}, // Sunday morning, continuing from Saturday
+		{"2017-09-11 03:00", 0},
+	} {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", tt.t, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rate := s.Rate(tm); rate != tt.rate {
+			t.Errorf("s.Rate(%s) = %v, expected %v", tt.t, rate, tt.rate)
+		}
+	}
+}
+
+func TestParseSchedule_Invalid(t *testing.T) {
+	for _, s := range []string{
+		"Mon-Fri 09:00 1MB",
+		"Mon-Fry 09:00-18:00 1MB",
+		"Mon 09:00-25:00 1MB",
+		"Mon 09:00-18:00 fast",
+		"default",
+	} {
+		if _, err := ParseSchedule(strings.NewReader(s)); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded", s)
+		}
+	}
+}
+
+type fakeClock struct {
+	now   time.Time
+	after chan time.Duration
+	fire  chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.after <- d
+	return c.fire
+}
+
+func TestSchedule_Run(t *testing.T) {
+	s, err := ParseSchedule(strings.NewReader(testSchedule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, _ := time.ParseInLocation("2006-01-02 15:04", "2017-09-04 08:00", time.Local)
+	c := &fakeClock{
+		now:   start,
+		after: make(chan time.Duration),
+		fire:  make(chan time.Time),
+	}
+	l := NewLimiter(nil, 0, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.Run(ctx, l, c)
+	}()
+	for _, want := range []struct {
+		wait time.Duration
+		rate float64
+	}{
+		{1 * time.Hour, 0},
+		{9 * time.Hour, 1e6},
+		{15 * time.Hour, 0},
+	} {
+		d := <-c.after
+		if rate, _ := l.Limit(); rate != want.rate {
+			t.Errorf("at %v rate = %v, expected %v", c.now, rate, want.rate)
+		}
+		if d != want.wait {
+			t.Errorf("at %v waiting %v, expected %v", c.now, d, want.wait)
+		}
+		c.now = c.now.Add(d)
+		c.fire <- c.now
+	}
+	<-c.after
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("s.Run() = %v, expected %v", err, context.Canceled)
+	}
+}
@@ -1,6 +1,27 @@
 package iox
 
-import "io"
+import (
+	"io"
+	"time"
+)
+
+// StatWriter is the Writer counterpart of StatReader.
+type StatWriter struct {
+	w io.Writer
+	stat
+}
+
+func NewStatWriter(w io.Writer, interval time.Duration) *StatWriter {
+	sw := &StatWriter{w: w}
+	sw.interval = interval
+	return sw
+}
+
+func (w *StatWriter) Write(p []byte) (n int, err error) {
+	n, err = w.w.Write(p)
+	w.add(n)
+	return
+}
 
 type sectionWriter struct {
 	w   io.WriterAt
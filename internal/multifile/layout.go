@@ -0,0 +1,58 @@
+package multifile
+
+import (
+	"path/filepath"
+
+	"github.com/takeyourhatoff/bt/bencode"
+)
+
+// A Layout decides where the files of a torrent are placed on disk. It returns
+// the path of the nth file in info as a list of components relative to the
+// directory the torrent is opened in. For single-file torrents n is always 0.
+//
+// Layouts only affect where data is read from and written to, the infohash of
+// the torrent is unchanged.
+type Layout func(info bencode.InfoDict, n int) []string
+
+// DefaultLayout places a single-file torrent at Name, and the files of a
+// multi-file torrent at Name/Path.
+func DefaultLayout(info bencode.InfoDict, n int) []string {
+	name := filepath.Base(info.Name)
+	if info.Length > 0 {
+		return []string{name}
+	}
+	return append([]string{name}, info.Files[n].Path...)
+}
+
+// NoRootLayout is like DefaultLayout but strips the top-level Name directory
+// from multi-file torrents, placing their files directly at Path.
+func NoRootLayout(info bencode.InfoDict, n int) []string {
+	if info.Length > 0 {
+		return DefaultLayout(info, n)
+	}
+	return info.Files[n].Path
+}
+
+// FlatLayout is like DefaultLayout but places every file of a multi-file
+// torrent directly in the Name directory, discarding the rest of its Path.
+func FlatLayout(info bencode.InfoDict, n int) []string {
+	if info.Length > 0 {
+		return DefaultLayout(info, n)
+	}
+	path := info.Files[n].Path
+	if len(path) == 0 {
+		return []string{filepath.Base(info.Name)}
+	}
+	return []string{filepath.Base(info.Name), path[len(path)-1]}
+}
+
+// Rename returns a Layout which places the files whose indices are keys of
+// names at the corresponding path, and all other files according to l.
+func Rename(l Layout, names map[int][]string) Layout {
+	return func(info bencode.InfoDict, n int) []string {
+		if path, ok := names[n]; ok {
+			return path
+		}
+		return l(info, n)
+	}
+}
@@ -4,11 +4,12 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/pkg/errors"
 
-	"github.com/takeyourhatoff/bt/internal/bencode"
+	"github.com/takeyourhatoff/bt/bencode"
 )
 
 // File is the interface returned by the methods in this package. Only the Close method is not safe for concurent use.
@@ -19,25 +20,107 @@ type File interface {
 	io.Closer
 }
 
-// Open opens the torrent in the named directory for seeding.
+// ErrMissing is the cause of the errors returned when reading from or writing to a file which OpenPartial did not
+// find.
+var ErrMissing = errors.New("file missing")
+
+// Open opens the torrent in the named directory for seeding. Files are looked for under their sanitised names, as
+// given by Paths, or else under the names in the torrent if those are legal here.
 func Open(dir string, i bencode.Metainfo) (File, error) {
-	return open(dir, i, os.O_RDONLY, 0)
+	return OpenLayout(dir, i, DefaultLayout)
+}
+
+// OpenPartial is like Open but allows files of the torrent to be missing, as those of an incomplete download may be.
+func OpenPartial(dir string, i bencode.Metainfo) (File, error) {
+	paths := existing(dir, i.Info, DefaultLayout, Paths(i.Info, DefaultLayout))
+	return open(dir, i, DefaultLayout, paths, os.O_RDONLY, 0, true)
 }
 
 // Create creates the directory structure in the named directory, and creates and initilises the files of the torrent with the correct size, ready for downloading.
 func Create(dir string, i bencode.Metainfo) (File, error) {
-	return open(dir, i, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	return CreateLayout(dir, i, DefaultLayout)
 }
 
 // Continue opens the torrent in the named directory for continuing an aborted download. All the files in the torrent should already be present and initilised with the correct size.
 func Continue(dir string, i bencode.Metainfo) (File, error) {
-	return open(dir, i, os.O_RDWR, 0)
+	return ContinueLayout(dir, i, DefaultLayout)
+}
+
+// OpenLayout is like Open but places the files of the torrent on disk according to l.
+func OpenLayout(dir string, i bencode.Metainfo, l Layout) (File, error) {
+	return open(dir, i, l, existing(dir, i.Info, l, Paths(i.Info, l)), os.O_RDONLY, 0, false)
+}
+
+// CreateLayout is like Create but places the files of the torrent on disk according to l.
+func CreateLayout(dir string, i bencode.Metainfo, l Layout) (File, error) {
+	return open(dir, i, l, Paths(i.Info, l), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666, false)
+}
+
+// ContinueLayout is like Continue but places the files of the torrent on disk according to l.
+func ContinueLayout(dir string, i bencode.Metainfo, l Layout) (File, error) {
+	return open(dir, i, l, existing(dir, i.Info, l, Paths(i.Info, l)), os.O_RDWR, 0, false)
+}
+
+// existing returns paths, the sanitised paths of the files of info placed according to l, with those which do not
+// exist in dir replaced by the names given by l verbatim, if they are safe to use and do exist. Files written under
+// names which are legal on this system but not on all, such as by the creator of the torrent, are then still found.
+func existing(dir string, info bencode.InfoDict, l Layout, paths [][]string) [][]string {
+	out := make([][]string, len(paths))
+	copy(out, paths)
+	for n, path := range paths {
+		if path == nil {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(dir, filepath.Join(path...))); err == nil {
+			continue
+		}
+		raw := l(info, n)
+		if !verbatim(raw) {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(dir, filepath.Join(raw...))); err == nil {
+			out[n] = raw
+		}
+	}
+	return out
+}
+
+// verbatim reports whether path can be used unsanitised without escaping the directory it is relative to: it has
+// no empty, . or .. components, and none containing a separator, a NUL or a volume name.
+func verbatim(path []string) bool {
+	if len(path) == 0 {
+		return false
+	}
+	for _, c := range path {
+		if c == "" || c == "." || c == ".." || strings.ContainsAny(c, "/\x00") ||
+			strings.ContainsRune(c, filepath.Separator) || filepath.VolumeName(c) != "" {
+			return false
+		}
+	}
+	return true
 }
 
-func open(dir string, i bencode.Metainfo, flag int, perm os.FileMode) (File, error) {
-	name := filepath.Join(dir, filepath.Base(i.Info.Name))
+// OpenPaths is like Open but reads the nth file of the torrent from paths[n], relative to dir, without any sanitisation.
+// It is intended for reading files whose names were taken from the local filesystem, such as when creating a torrent.
+func OpenPaths(dir string, i bencode.Metainfo, paths [][]string) (File, error) {
+	return open(dir, i, nil, paths, os.O_RDONLY, 0, false)
+}
+
+// open opens the files of the torrent at paths, relative to dir, which were placed according to l. If partial is
+// set, files which do not exist are replaced by ones whose reads and writes fail with ErrMissing.
+func open(dir string, i bencode.Metainfo, l Layout, paths [][]string, flag int, perm os.FileMode, partial bool) (File, error) {
 	if i.Info.Length > 0 {
+		name := filepath.Join(dir, filepath.Join(paths[0]...))
+		if flag&os.O_CREATE != 0 {
+			err := os.MkdirAll(filepath.Dir(name), 0775)
+			if err != nil {
+				return nil, errors.Wrap(err, "creating directory")
+			}
+		}
 		f, err := os.OpenFile(name, flag, perm)
+		if partial && os.IsNotExist(err) {
+			return &multiFile{files: []file{{missing(name), i.Info.Length}}}, nil
+		}
 		if err != nil {
 			return nil, errors.Wrap(err, "opening file")
 		}
@@ -51,30 +134,124 @@ func open(dir string, i bencode.Metainfo, flag int, perm os.FileMode) (File, err
 	}
 	mf := new(multiFile)
 	var offset int64
-	for _, fi := range i.Info.Files {
-		fullName := filepath.Join(fi.Path...)
-		fullName = filepath.Clean(fullName)
-		fullName = filepath.Join(name, fullName)
-		if flag&os.O_CREATE != 0 {
-			err := os.MkdirAll(filepath.Dir(fullName), 0775)
+	for n, fi := range i.Info.Files {
+		var target string
+		if fi.IsSymlink() && flag&os.O_CREATE != 0 {
+			var err error
+			target, err = symlinkTarget(i.Info, l, paths, n)
 			if err != nil {
-				return nil, errors.Wrap(err, "creating directory")
+				mf.Close()
+				return nil, err
 			}
 		}
-		f, err := os.OpenFile(fullName, flag, perm)
+		f, err := openFile(dir, paths[n], fi, target, flag, perm)
+		if partial && os.IsNotExist(errors.Cause(err)) {
+			f, err = missing(filepath.Join(dir, filepath.Join(paths[n]...))), nil
+		}
 		if err != nil {
-			return nil, errors.Wrap(err, "opening file")
+			mf.Close()
+			return nil, err
 		}
+		offset += fi.Length
+		mf.files = append(mf.files, file{f, offset})
+	}
+	return mf, nil
+}
+
+// openFile opens the file fi of a multi-file torrent at path, relative to dir. Padding files and symbolic links
+// hold no data on disk, so reads from them return zeros and writes to them are discarded. Symbolic links are created
+// pointing at target.
+func openFile(dir string, path []string, fi bencode.File, target string, flag int, perm os.FileMode) (fileAt, error) {
+	if fi.IsPadding() {
+		return zeros{}, nil
+	}
+	name := filepath.Join(dir, filepath.Join(path...))
+	if flag&os.O_CREATE != 0 {
+		err := os.MkdirAll(filepath.Dir(name), 0775)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating directory")
+		}
+	}
+	if fi.IsSymlink() {
 		if flag&os.O_CREATE != 0 {
-			err = f.Truncate(fi.Length)
+			err := os.Symlink(target, name)
 			if err != nil {
-				return nil, errors.Wrap(err, "truncating file")
+				return nil, errors.Wrap(err, "creating symlink")
 			}
 		}
-		offset += fi.Length
-		mf.files = append(mf.files, file{f, offset})
+		return zeros{}, nil
 	}
-	return mf, nil
+	if fi.IsExecutable() {
+		perm |= 0111
+	}
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening file")
+	}
+	if flag&os.O_CREATE != 0 {
+		err = f.Truncate(fi.Length)
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrap(err, "truncating file")
+		}
+	}
+	return f, nil
+}
+
+// symlinkTarget returns the target of the symlink which is file n of info, relative to the directory holding it, where
+// the files of info were placed at paths according to l. SymlinkPath names a file or directory of the torrent, which
+// is found through paths so that the link points to it wherever it is placed on disk. Targets which are not in the
+// torrent are refused.
+func symlinkTarget(info bencode.InfoDict, l Layout, paths [][]string, n int) (string, error) {
+	fi := info.Files[n]
+	target := fi.SymlinkPath
+	if !verbatim(target) {
+		return "", errors.Errorf("symlink %q: target %q is not a path inside the torrent", fi.Path, target)
+	}
+	root, keeps := layoutRoot(l(info, n), fi.Path)
+	for k, f := range info.Files {
+		if k == n || paths[k] == nil || !hasPrefix(f.Path, target) {
+			continue
+		}
+		disk := paths[k]
+		if len(f.Path) > len(target) {
+			// A directory is found from a file in it, if l keeps the directories of both that file and the link
+			// under the same root.
+			r, ok := layoutRoot(l(info, k), f.Path)
+			if !keeps || !ok || !equal(r, root) || len(r)+len(target) < 1 {
+				continue
+			}
+			disk = paths[k][:len(r)+len(target)]
+		}
+		return filepath.Rel(filepath.Dir(filepath.Join(paths[n]...)), filepath.Join(disk...))
+	}
+	return "", errors.Errorf("symlink %q: target %q is not in the torrent", fi.Path, target)
+}
+
+// layoutRoot returns the directory a layout placed a file with the torrent path path in, if it placed it at
+// path within that directory.
+func layoutRoot(placed, path []string) ([]string, bool) {
+	root := len(placed) - len(path)
+	if root < 0 || !equal(placed[root:], path) {
+		return nil, false
+	}
+	return placed[:root], true
+}
+
+func hasPrefix(path, prefix []string) bool {
+	return len(path) >= len(prefix) && equal(path[:len(prefix)], prefix)
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
 
 type sizeFile struct {
@@ -86,11 +263,50 @@ func (f *sizeFile) Size() int64 {
 	return f.length
 }
 
+type fileAt interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+}
+
 type file struct {
-	f     *os.File
+	f     fileAt
 	limit int64
 }
 
+// zeros is the contents of a file which is not stored on disk.
+type zeros struct{}
+
+func (zeros) ReadAt(p []byte, off int64) (n int, err error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func (zeros) WriteAt(p []byte, off int64) (n int, err error) {
+	return len(p), nil
+}
+
+func (zeros) Close() error {
+	return nil
+}
+
+// missing is the named file which OpenPartial did not find.
+type missing string
+
+func (m missing) ReadAt(p []byte, off int64) (n int, err error) {
+	return 0, errors.Wrap(ErrMissing, string(m))
+}
+
+func (m missing) WriteAt(p []byte, off int64) (n int, err error) {
+	return 0, errors.Wrap(ErrMissing, string(m))
+}
+
+func (missing) Close() error {
+	return nil
+}
+
 type multiFile struct {
 	files []file
 	sync.Mutex
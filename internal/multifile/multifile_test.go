@@ -5,9 +5,14 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
-	"github.com/takeyourhatoff/bt/internal/bencode"
+	"github.com/pkg/errors"
+
+	"github.com/takeyourhatoff/bt/bencode"
 	"github.com/takeyourhatoff/bt/internal/iox"
 )
 
@@ -106,3 +111,305 @@ func (r *repeatReader) Read(p []byte) (n int, err error) {
 	r.off = (r.off + n) % len(r.b)
 	return n, nil
 }
+
+func TestLayout(t *testing.T) {
+	m := bencode.Metainfo{
+		Info: bencode.InfoDict{
+			Name: "test_torrent",
+			Files: []bencode.File{
+				{Length: 3,
+					Path: []string{"a", "one"}},
+				{Length: 5,
+					Path: []string{"b", "two"}},
+				{Length: 7,
+					Path: []string{"three"}},
+			},
+		},
+	}
+	l := Rename(NoRootLayout, map[int][]string{2: {"renamed"}})
+	want := []string{"a/one", "b/two", "renamed"}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f, err := CreateLayout(dir, m, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, name := range want {
+		fi, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if fi.Size() != m.Info.Files[n].Length {
+			t.Errorf("%s has size %d, expected %d", name, fi.Size(), m.Info.Files[n].Length)
+		}
+	}
+	f, err = OpenLayout(dir, m, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.Size() != 15 {
+		t.Errorf("f.Size() = %d, expected 15", f.Size())
+	}
+}
+
+func TestSanitise(t *testing.T) {
+	long := strings.Repeat("é", 200)
+	for _, tt := range []struct {
+		in, out string
+	}{
+		{"ok.txt", "ok.txt"},
+		{"a:b*c?.txt", "a_b_c_.txt"},
+		{"trailing. .", "trailing___"},
+		{"", "_"},
+		{".", "_"},
+		{"..", "__"},
+		{"CON", "CON_"},
+		{"con.txt", "con_.txt"},
+		{"CONSOLE", "CONSOLE"},
+		{"bad\xffutf8", "bad\uFFFDutf8"},
+		{"é", "é"},
+		{long + ".txt", strings.Repeat("é", 125) + ".txt"},
+	} {
+		if out := sanitise(tt.in); out != tt.out {
+			t.Errorf("sanitise(%q) = %q, expected %q", tt.in, out, tt.out)
+		}
+	}
+}
+
+func TestPaths(t *testing.T) {
+	info := bencode.InfoDict{
+		Name: "root",
+		Files: []bencode.File{
+			{Path: []string{"Dir", "a.txt"}},
+			{Path: []string{"dir", "A.txt"}},
+			{Path: []string{"dir", "b"}},
+			{Path: []string{"DIR", "b", "c"}},
+			{Path: []string{"é"}},
+			{Path: []string{"é"}},
+		},
+	}
+	want := [][]string{
+		{"root", "Dir", "a.txt"},
+		{"root", "Dir", "A (2).txt"},
+		{"root", "Dir", "b"},
+		{"root", "Dir", "b (2)", "c"},
+		{"root", "é"},
+		{"root", "é (2)"},
+	}
+	for i := 0; i < 2; i++ {
+		if got := Paths(info, DefaultLayout); !reflect.DeepEqual(got, want) {
+			t.Errorf("Paths() = %q, expected %q", got, want)
+		}
+	}
+}
+
+func TestPadding(t *testing.T) {
+	m := bencode.Metainfo{
+		Info: bencode.InfoDict{
+			Name: "test_torrent",
+			Files: []bencode.File{
+				{Length: 3,
+					Path: []string{"one"}},
+				{Length: 5,
+					Path: []string{".pad", "5"},
+					Attr: "p"},
+				{Length: 2,
+					Path: []string{"two"}},
+			},
+		},
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f, err := Create(dir, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = os.Stat(filepath.Join(dir, "test_torrent", ".pad"))
+	if !os.IsNotExist(err) {
+		t.Errorf("padding file was created on disk: %v", err)
+	}
+	_, err = io.Copy(iox.NewSectionWriter(f, 0), strings.NewReader("abcxxxxxde"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(io.NewSectionReader(f, 0, f.Size()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "abc\x00\x00\x00\x00\x00de"; string(b) != want {
+		t.Errorf("read %q, expected %q", b, want)
+	}
+}
+
+func TestOpenPartial(t *testing.T) {
+	m := bencode.Metainfo{
+		Info: bencode.InfoDict{
+			Name: "test_torrent",
+			Files: []bencode.File{
+				{Length: 3,
+					Path: []string{"one"}},
+				{Length: 2,
+					Path: []string{"two"}},
+			},
+		},
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f, err := Create(dir, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := os.Remove(filepath.Join(dir, "test_torrent", "two")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir, m); err == nil {
+		t.Error("Open succeeded with a file missing")
+	}
+	f, err = OpenPartial(dir, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p := make([]byte, 3)
+	if _, err := f.ReadAt(p, 0); err != nil {
+		t.Errorf("reading one: %v", err)
+	}
+	if _, err := f.ReadAt(p[:2], 3); errors.Cause(err) != ErrMissing {
+		t.Errorf("reading two = %v, expected %v", err, ErrMissing)
+	}
+
+	m.Info.Name, m.Info.Length, m.Info.Files = "gone", 5, nil
+	f, err = OpenPartial(dir, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.ReadAt(p, 0); errors.Cause(err) != ErrMissing || f.Size() != 5 {
+		t.Errorf("reading a missing single file = %v, expected %v", err, ErrMissing)
+	}
+}
+
+func TestOpen_Verbatim(t *testing.T) {
+	if filepath.Separator != '/' {
+		t.Skip("colons are not legal in names on this system")
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, data := range map[string]string{"root/x:y": "abc", "secret": "zz"} {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := bencode.Metainfo{
+		Info: bencode.InfoDict{
+			Name: "root",
+			Files: []bencode.File{
+				{Length: 3,
+					Path: []string{"x:y"}},
+			},
+		},
+	}
+	f, err := Open(dir, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(io.NewSectionReader(f, 0, f.Size()))
+	if err != nil || string(b) != "abc" {
+		t.Errorf("read %q, %v, expected %q", b, err, "abc")
+	}
+
+	m.Info.Files = []bencode.File{{Length: 2, Path: []string{"..", "secret"}}}
+	if f, err := Open(dir, m); err == nil {
+		f.Close()
+		t.Error("Open used a path outside the torrent's directory")
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	files := []bencode.File{
+		{Length: 3, Path: []string{"d", "file"}},
+		{Path: []string{"d", "rel"}, Attr: "l", SymlinkPath: []string{"d", "file"}},
+		{Path: []string{"top"}, Attr: "l", SymlinkPath: []string{"d", "file"}},
+		{Path: []string{"x", "dirlink"}, Attr: "l", SymlinkPath: []string{"d"}},
+	}
+	tests := []struct {
+		layout Layout
+		files  []bencode.File
+		links  map[string]string // on disk, to their targets, or nil if the links cannot be made
+	}{
+		{DefaultLayout, files, map[string]string{"root/d/rel": "file", "root/top": "d/file", "root/x/dirlink": "../d"}},
+		{NoRootLayout, files, map[string]string{"d/rel": "file", "top": "d/file", "x/dirlink": "../d"}},
+		{Rename(DefaultLayout, map[int][]string{0: {"elsewhere", "f"}}), files[:3],
+			map[string]string{"root/d/rel": "../../elsewhere/f", "root/top": "../elsewhere/f"}},
+		{FlatLayout, files[:3], map[string]string{"root/rel": "file", "root/top": "file"}},
+		// Directories are not kept, so dirlink has nothing to point to.
+		{FlatLayout, files, nil},
+	}
+	for i, tt := range tests {
+		dir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		m := bencode.Metainfo{Info: bencode.InfoDict{Name: "root", Files: tt.files}}
+		f, err := CreateLayout(dir, m, tt.layout)
+		if tt.links == nil {
+			if err == nil {
+				f.Close()
+				t.Errorf("layout %d: CreateLayout succeeded", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("layout %d: %v", i, err)
+		}
+		f.Close()
+		for name, want := range tt.links {
+			got, err := os.Readlink(filepath.Join(dir, filepath.FromSlash(name)))
+			if err != nil || got != filepath.FromSlash(want) {
+				t.Errorf("layout %d: %s links to %q, %v, expected %q", i, name, got, err, want)
+			}
+		}
+	}
+
+	for _, target := range [][]string{{"..", "secret"}, {"/etc", "passwd"}, {}, {"missing"}} {
+		dir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		m := bencode.Metainfo{Info: bencode.InfoDict{Name: "root", Files: []bencode.File{
+			{Length: 3, Path: []string{"file"}},
+			{Path: []string{"link"}, Attr: "l", SymlinkPath: target},
+		}}}
+		if f, err := Create(dir, m); err == nil {
+			f.Close()
+			t.Errorf("Create with a symlink to %q succeeded", target)
+		}
+	}
+}
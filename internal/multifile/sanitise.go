@@ -0,0 +1,160 @@
+package multifile
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/takeyourhatoff/bt/bencode"
+)
+
+// maxComponent is the longest path component, in bytes, that we will create.
+// It is the limit of most common filesystems.
+const maxComponent = 255
+
+// maxExt is the longest file extension that is preserved when a component is
+// shortened to fit in maxComponent.
+const maxExt = 32
+
+// Paths returns the on-disk path of each file in info placed according to l,
+// as lists of components relative to the directory the torrent is opened in.
+//
+// Every component is sanitised so that it is legal on all common filesystems,
+// and files whose paths would collide once case and Unicode normalisation are
+// ignored are renamed by adding a numbered suffix. Directories which differ only
+// in case are merged using the spelling of the first one. Padding files are not
+// stored on disk and have a nil path. The result depends only on info and l, so
+// a torrent always maps to the same names.
+func Paths(info bencode.InfoDict, l Layout) [][]string {
+	n := 1
+	if info.Length == 0 {
+		n = len(info.Files)
+	}
+	nm := namer{
+		dirs:  make(map[string]string),
+		files: make(map[string]bool),
+	}
+	paths := make([][]string, n)
+	for i := range paths {
+		if info.Length == 0 && info.Files[i].IsPadding() {
+			// Padding files are not stored on disk, so cannot collide.
+			continue
+		}
+		paths[i] = nm.name(l(info, i))
+	}
+	return paths
+}
+
+type namer struct {
+	dirs  map[string]string // folded path of each directory to its on-disk name
+	files map[string]bool   // folded path of each file
+}
+
+func (nm *namer) name(path []string) []string {
+	if len(path) == 0 {
+		path = []string{""}
+	}
+	out := make([]string, len(path))
+	var key string
+	for i, c := range path {
+		c = sanitise(c)
+		last := i == len(path)-1
+		for k := 1; ; k++ {
+			name := c
+			if k > 1 {
+				name = withSuffix(c, k)
+			}
+			kk := key + "/" + fold(name)
+			if last {
+				if _, ok := nm.dirs[kk]; !ok && !nm.files[kk] {
+					nm.files[kk] = true
+					out[i], key = name, kk
+					break
+				}
+			} else {
+				if d, ok := nm.dirs[kk]; ok {
+					out[i], key = d, kk
+					break
+				}
+				if !nm.files[kk] {
+					nm.dirs[kk] = name
+					out[i], key = name, kk
+					break
+				}
+			}
+		}
+	}
+	return out
+}
+
+func fold(name string) string {
+	return strings.ToLower(name)
+}
+
+// sanitise returns a version of the path component c which is valid UTF-8 in
+// normalisation form C, and is legal on Windows, macOS and Linux.
+func sanitise(c string) string {
+	c = strings.ToValidUTF8(c, "\uFFFD")
+	c = norm.NFC.String(c)
+	c = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, c)
+	c = shorten(c, "")
+	// Windows silently strips trailing dots and spaces.
+	b := []byte(c)
+	for i := len(b) - 1; i >= 0 && (b[i] == '.' || b[i] == ' '); i-- {
+		b[i] = '_'
+	}
+	c = string(b)
+	if c == "" {
+		return "_"
+	}
+	stem := c
+	if i := strings.IndexByte(c, '.'); i >= 0 {
+		stem = c[:i]
+	}
+	if reserved(stem) {
+		c = stem + "_" + c[len(stem):]
+	}
+	return c
+}
+
+// reserved reports whether stem is a device name on Windows.
+func reserved(stem string) bool {
+	switch strings.ToUpper(strings.TrimRight(stem, " ")) {
+	case "CON", "PRN", "AUX", "NUL",
+		"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+		"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9":
+		return true
+	}
+	return false
+}
+
+// withSuffix returns c with " (k)" inserted before its extension.
+func withSuffix(c string, k int) string {
+	return shorten(c, " ("+strconv.Itoa(k)+")")
+}
+
+// shorten returns c with suffix inserted before its extension, cutting the
+// stem at a rune boundary so that the result is at most maxComponent bytes.
+func shorten(c, suffix string) string {
+	ext := filepath.Ext(c)
+	if len(ext) > maxExt || len(ext) == len(c) {
+		ext = ""
+	}
+	stem := c[:len(c)-len(ext)]
+	n := maxComponent - len(ext) - len(suffix)
+	if len(stem) > n {
+		for n > 0 && !utf8.RuneStart(stem[n]) {
+			n--
+		}
+		stem = stem[:n]
+	}
+	return stem + suffix + ext
+}
@@ -0,0 +1,94 @@
+// Package piece provides access to the data of a torrent one piece at a time.
+package piece
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+
+	"github.com/takeyourhatoff/bt/bencode"
+	"github.com/takeyourhatoff/bt/internal/iox"
+	"github.com/takeyourhatoff/bt/internal/multifile"
+)
+
+// Store reads, writes and verifies the pieces of a torrent stored in a multifile.File. It is safe for concurrent use.
+type Store struct {
+	f      multifile.File
+	info   bencode.InfoDict
+	pieces [][]byte
+}
+
+// NewStore returns a Store for the torrent described by info, whose data is in f.
+func NewStore(f multifile.File, info bencode.InfoDict) *Store {
+	return &Store{
+		f:      f,
+		info:   info,
+		pieces: info.Pieces(),
+	}
+}
+
+// NumPieces returns the number of pieces in the torrent.
+func (s *Store) NumPieces() int {
+	return len(s.pieces)
+}
+
+// ReadPiece reads the nth piece. If its SHA-1 hash is incorrect the cause of the returned error is iox.ErrChecksum.
+func (s *Store) ReadPiece(n int) ([]byte, error) {
+	r, length, err := s.reader(n)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, length))
+	_, err = buf.ReadFrom(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading piece %d", n)
+	}
+	return buf.Bytes(), nil
+}
+
+// WritePiece writes data as the nth piece. Nothing is written if data has the wrong length or SHA-1 hash,
+// and in the latter case the cause of the returned error is iox.ErrChecksum.
+func (s *Store) WritePiece(n int, data []byte) error {
+	off, length, err := s.bounds(n)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != length {
+		return errors.Errorf("piece %d has length %d, expected %d", n, len(data), length)
+	}
+	if sum := sha1.Sum(data); !bytes.Equal(sum[:], s.pieces[n]) {
+		return errors.Wrapf(iox.ErrChecksum, "writing piece %d", n)
+	}
+	_, err = s.f.WriteAt(data, off)
+	return errors.Wrapf(err, "writing piece %d", n)
+}
+
+// VerifyPiece reads the nth piece and checks its SHA-1 hash. If it is incorrect the cause of the returned error is iox.ErrChecksum.
+func (s *Store) VerifyPiece(n int) error {
+	r, _, err := s.reader(n)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(ioutil.Discard, r)
+	return errors.Wrapf(err, "verifying piece %d", n)
+}
+
+func (s *Store) reader(n int) (r io.Reader, length int64, err error) {
+	off, length, err := s.bounds(n)
+	if err != nil {
+		return nil, 0, err
+	}
+	r = io.NewSectionReader(s.f, off, length)
+	return iox.NewChecksumReader(r, sha1.New(), s.pieces[n]), length, nil
+}
+
+func (s *Store) bounds(n int) (off, length int64, err error) {
+	if n < 0 || n >= len(s.pieces) {
+		return 0, 0, errors.Errorf("piece %d out of range: 0 <= n < %d", n, len(s.pieces))
+	}
+	off, length = s.info.PieceBounds(n)
+	return off, length, nil
+}
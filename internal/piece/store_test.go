@@ -0,0 +1,89 @@
+package piece
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/takeyourhatoff/bt/bencode"
+	"github.com/takeyourhatoff/bt/internal/iox"
+	"github.com/takeyourhatoff/bt/internal/multifile"
+)
+
+func TestStore(t *testing.T) {
+	data := []byte("TheQuickBrownFoxJumpedOverTheLazyDog")
+	m := bencode.Metainfo{
+		Info: bencode.InfoDict{
+			Name:        "test_torrent",
+			PieceLength: 16,
+			Files: []bencode.File{
+				{Length: 10,
+					Path: []string{"a"}},
+				{Length: 26,
+					Path: []string{"b"}},
+			},
+		},
+	}
+	for off := 0; off < len(data); off += 16 {
+		end := off + 16
+		if end > len(data) {
+			end = len(data)
+		}
+		sum := sha1.Sum(data[off:end])
+		m.Info.RawPieces = append(m.Info.RawPieces, sum[:]...)
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f, err := multifile.Create(dir, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s := NewStore(f, m.Info)
+	if n := s.NumPieces(); n != 3 {
+		t.Fatalf("s.NumPieces() = %d, expected 3", n)
+	}
+	for n := 0; n < s.NumPieces(); n++ {
+		if err := s.VerifyPiece(n); errors.Cause(err) != iox.ErrChecksum {
+			t.Errorf("s.VerifyPiece(%d) = %v before writing, expected %v", n, err, iox.ErrChecksum)
+		}
+	}
+	if err := s.WritePiece(0, data[16:32]); errors.Cause(err) != iox.ErrChecksum {
+		t.Errorf("s.WritePiece(0, bad data) = %v, expected %v", err, iox.ErrChecksum)
+	}
+	if err := s.WritePiece(2, data[32:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WritePiece(2, data[30:]); err == nil {
+		t.Error("s.WritePiece(2, long data) succeeded")
+	}
+	for n := 0; n < 2; n++ {
+		if err := s.WritePiece(n, data[n*16:(n+1)*16]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var b []byte
+	for n := 0; n < s.NumPieces(); n++ {
+		if err := s.VerifyPiece(n); err != nil {
+			t.Error(err)
+		}
+		p, err := s.ReadPiece(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = append(b, p...)
+	}
+	if !bytes.Equal(b, data) {
+		t.Errorf("read %q, expected %q", b, data)
+	}
+	if _, err := s.ReadPiece(3); err == nil {
+		t.Error("s.ReadPiece(3) succeeded")
+	}
+}
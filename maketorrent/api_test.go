@@ -0,0 +1,64 @@
+package maketorrent_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/takeyourhatoff/bt/bencode"
+	"github.com/takeyourhatoff/bt/maketorrent"
+)
+
+// TestAPI uses the package as another module would, through its exported names only.
+func TestAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0775); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "a"), make([]byte, 100000), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	b := maketorrent.Builder{
+		PieceLength: maketorrent.MinPieceLength,
+		Trackers:    [][]string{{"http://tracker/announce"}},
+		Pool:        maketorrent.NewPool(1, 0),
+	}
+	var m bencode.Metainfo
+	m, err = b.Build(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "root.torrent")
+	if err := maketorrent.WriteFile(out, m); err != nil {
+		t.Fatal(err)
+	}
+	read, err := maketorrent.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.Matches(read, root); !ok || err != nil {
+		t.Errorf("torrent read back does not match the builder which created it: %v", err)
+	}
+	if magnet := maketorrent.Magnet(read); !strings.HasPrefix(magnet, "magnet:?xt=urn:btih:") {
+		t.Errorf("Magnet() = %q", magnet)
+	}
+
+	b.Previous, b.PreviousTime = &read.Info, read.CreationDate
+	again, err := b.Build(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Info.RawPieces, read.Info.RawPieces) {
+		t.Error("rebuilding with the previous torrent changed the piece hashes")
+	}
+}
@@ -0,0 +1,108 @@
+package maketorrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/takeyourhatoff/bt/bencode"
+)
+
+// DefaultCheckpointInterval is how often hashes are checkpointed when no interval is given.
+const DefaultCheckpointInterval = time.Minute
+
+// ErrStaleCheckpoint is returned, wrapped, when resuming from a checkpoint of different files.
+var ErrStaleCheckpoint = errors.New("checkpoint is of different files")
+
+// checkpoint is the hashing done so far of a torrent, along with what is needed to tell whether its files have
+// changed since.
+type checkpoint struct {
+	PieceLength int64            `bencode:"piece length"`
+	Files       []checkpointFile `bencode:"files"`
+	Pieces      []byte           `bencode:"pieces"` // the hash of each piece, or zeros if it is not yet hashed
+}
+
+type checkpointFile struct {
+	Path    []string `bencode:"path"`
+	Length  int64    `bencode:"length"`
+	Attr    string   `bencode:"attr,ommitempty"`
+	ModTime int64    `bencode:"mtime"` // nanoseconds since the epoch, or 0 if the file is not on disk
+}
+
+// newCheckpoint returns an empty checkpoint of info, whose files have the modification times in mtimes, keyed by
+// their slash separated paths.
+func newCheckpoint(info *bencode.InfoDict, mtimes map[string]time.Time) *checkpoint {
+	c := &checkpoint{PieceLength: info.PieceLength}
+	for _, f := range fileList(info) {
+		var mtime int64
+		if t := mtimes[strings.Join(f.Path, "/")]; !t.IsZero() && !f.IsPadding() {
+			mtime = t.UnixNano()
+		}
+		c.Files = append(c.Files, checkpointFile{f.Path, f.Length, f.Attr, mtime})
+	}
+	return c
+}
+
+// resume returns known with the hashes saved in the checkpoint file name added, or known unchanged if the file does
+// not exist.
+func (c *checkpoint) resume(name string, known [][]byte, n int) ([][]byte, error) {
+	var saved checkpoint
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return known, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	err = bencode.Decode(f, &saved)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading checkpoint %s", name)
+	}
+	if saved.PieceLength != c.PieceLength || !reflect.DeepEqual(saved.Files, c.Files) || len(saved.Pieces) != n*sha1.Size {
+		return nil, errors.Wrap(ErrStaleCheckpoint, name)
+	}
+	if known == nil {
+		known = make([][]byte, n)
+	}
+	zero := make([]byte, sha1.Size)
+	for i := range known {
+		if h := saved.Pieces[i*sha1.Size : (i+1)*sha1.Size]; !bytes.Equal(h, zero) {
+			known[i] = h
+		}
+	}
+	return known, nil
+}
+
+// write saves c, with the hashes in p, to the file name. The file is replaced atomically, so that it is never
+// left half written.
+func (c *checkpoint) write(name string, p [][]byte) (err error) {
+	c.Pieces = make([]byte, len(p)*sha1.Size)
+	for i, h := range p {
+		copy(c.Pieces[i*sha1.Size:], h)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return errors.Wrap(err, "writing checkpoint")
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+	err = bencode.Encode(f, c)
+	if err0 := f.Close(); err == nil {
+		err = err0
+	}
+	if err != nil {
+		return errors.Wrap(err, "writing checkpoint")
+	}
+	return errors.Wrap(os.Rename(f.Name(), name), "writing checkpoint")
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package maketorrent
+
+import (
+	"os"
+	"syscall"
+)
+
+// device returns the id of the device holding the named file.
+func device(name string) (uint64, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return 0, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, nil
+	}
+	return uint64(st.Dev), nil
+}
@@ -0,0 +1,340 @@
+package maketorrent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/takeyourhatoff/bt/bencode"
+	"github.com/takeyourhatoff/bt/internal/iox"
+)
+
+const (
+	// blockSize is the size of the reads which feed the hashers. Reads this large keep a spinning disk reading
+	// sequentially while the hashers work on earlier blocks.
+	blockSize = 4 << 20
+
+	// maxBuffered bounds the memory held in blocks which have been read but not yet hashed.
+	maxBuffered = 256 << 20
+)
+
+// span is a range of pieces, [first, end), which are read from one device.
+type span struct {
+	first, end int
+}
+
+// block is a run of whole pieces read from disk, starting with piece first.
+type block struct {
+	first int
+	buf   []byte
+}
+
+// hashJob describes the hashing of the pieces of a torrent.
+type hashJob struct {
+	info    *bencode.InfoDict
+	devices []uint64 // the device holding each file of info, or nil if they are all on one
+
+	// v1 is set if the SHA-1 hash of each piece is wanted. Pieces whose hashes are already known, as non-nil
+	// entries of known, are not read unless verify is set, in which case it is an error for them to have changed.
+	v1     bool
+	known  [][]byte
+	verify bool
+
+	// v2 is set if the BEP 52 SHA-256 merkle hash of each piece is wanted, in which case every file of info must
+	// start on a piece boundary.
+	v2 bool
+
+	// save, if not nil, is called every Builder.CheckpointInterval with the SHA-1 hashes so far, with nil for those
+	// not yet hashed, and hashing fails if it does.
+	save func([][]byte) error
+}
+
+// pieces returns the SHA-1 and SHA-256 hashes of each piece of r, which holds the files of j.info, as asked for by
+// j. Each device holding the files is read sequentially by its own reader, which passes blocks of pieces to the
+// hashers of b.Pool, or one hasher per CPU if it is nil. The SHA-1 hashes so far are also returned if hashing fails.
+func (b *Builder) pieces(ctx context.Context, r io.ReaderAt, j *hashJob) (v1, v2 [][]byte, err error) {
+	info := j.info
+	total := info.TotalLength()
+	pieceLength := info.PieceLength
+	skip := j.known
+	if j.verify {
+		skip = nil
+	}
+	var layout []v2Piece
+	if j.v2 {
+		layout = v2Layout(info)
+	}
+	var toHash int64
+	for i := 0; i < numPieces(info); i++ {
+		if i >= len(skip) || skip[i] == nil {
+			_, length := pieceBounds(i, pieceLength, total)
+			toHash += length
+		}
+	}
+	perBlock := int(blockSize / pieceLength)
+	if perBlock < 1 {
+		perBlock = 1
+	}
+	spans := deviceSpans(info, j.devices, skip)
+	pool := b.Pool
+	if pool == nil {
+		pool = NewPool(0, 0)
+	}
+	workers := pool.workers
+	nbuf := workers + len(spans)
+	if max := int(maxBuffered / (int64(perBlock) * pieceLength)); nbuf > max {
+		nbuf = max
+	}
+	if nbuf < len(spans)+1 {
+		nbuf = len(spans) + 1
+	}
+	// Buffers are allocated when first needed, so that small torrents use little memory.
+	free := make(chan []byte, nbuf)
+	for i := 0; i < nbuf; i++ {
+		free <- nil
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	blocks := make(chan block)
+	var readers sync.WaitGroup
+	for _, s := range spans {
+		s := s
+		readers.Add(1)
+		g.Go(func() error {
+			defer readers.Done()
+			return readSpans(ctx, r, pool.limiter, s, pieceLength, total, perBlock, free, blocks)
+		})
+	}
+	go func() {
+		readers.Wait()
+		close(blocks)
+	}()
+
+	p := make([][]byte, numPieces(info))
+	copy(p, skip)
+	var p2 [][]byte
+	if j.v2 {
+		p2 = make([][]byte, len(p))
+	}
+	var mu sync.Mutex // guards p, p2 and hashed
+	var hashed int64
+	snapshot := func() [][]byte {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([][]byte(nil), p...)
+	}
+	var hashers sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		hashers.Add(1)
+		g.Go(func() error {
+			defer hashers.Done()
+			h := sha1.New()
+			hash := func(bl block) error {
+				if err := pool.acquire(ctx); err != nil {
+					return err
+				}
+				defer pool.release()
+				for off := int64(0); off < int64(len(bl.buf)); off += pieceLength {
+					if err := ctx.Err(); err != nil {
+						return err
+					}
+					end := off + pieceLength
+					if end > int64(len(bl.buf)) {
+						end = int64(len(bl.buf))
+					}
+					i := bl.first + int(off/pieceLength)
+					var sum, sum2 []byte
+					if j.v1 {
+						h.Reset()
+						h.Write(bl.buf[off:end])
+						sum = h.Sum(nil)
+						if i < len(j.known) && j.known[i] != nil && !bytes.Equal(sum, j.known[i]) {
+							return errors.Errorf("piece %d has changed since the previous torrent", i)
+						}
+					}
+					if j.v2 {
+						sum2 = layout[i].hash(bl.buf[off:end], pieceLength)
+					}
+					mu.Lock()
+					p[i] = sum
+					if j.v2 {
+						p2[i] = sum2
+					}
+					hashed += end - off
+					if b.Progress != nil {
+						b.Progress(hashed, toHash)
+					}
+					mu.Unlock()
+				}
+				return nil
+			}
+			for bl := range blocks {
+				if err := hash(bl); err != nil {
+					return err
+				}
+				free <- bl.buf
+			}
+			return nil
+		})
+	}
+	go func() {
+		hashers.Wait()
+		close(done)
+	}()
+	if j.save != nil {
+		interval := b.CheckpointInterval
+		if interval == 0 {
+			interval = DefaultCheckpointInterval
+		}
+		g.Go(func() error {
+			t := time.NewTicker(interval)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+					if err := j.save(snapshot()); err != nil {
+						return err
+					}
+				case <-done:
+					return nil
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		})
+	}
+	err = g.Wait()
+	if err != nil {
+		return snapshot(), nil, err
+	}
+	if !j.v1 {
+		p = nil
+	}
+	return p, p2, nil
+}
+
+// numPieces returns the number of pieces of info, which has yet to be hashed.
+func numPieces(info *bencode.InfoDict) int {
+	return int((info.TotalLength() + info.PieceLength - 1) / info.PieceLength)
+}
+
+// pieceBounds returns the offset and length of piece n of a torrent of total bytes.
+func pieceBounds(n int, pieceLength, total int64) (off, length int64) {
+	off = int64(n) * pieceLength
+	length = pieceLength
+	if off+length > total {
+		length = total - off
+	}
+	return
+}
+
+// readSpans reads the pieces in spans from r, in order, in blocks of up to perBlock pieces, which are sent to
+// blocks, no faster than limiter permits if it is not nil. Buffers are taken from free, where nil means a buffer is
+// yet to be allocated.
+func readSpans(ctx context.Context, r io.ReaderAt, limiter *iox.Limiter, spans []span, pieceLength, total int64, perBlock int, free chan []byte, blocks chan<- block) error {
+	for _, s := range spans {
+		for first := s.first; first < s.end; first += perBlock {
+			n := s.end - first
+			if n > perBlock {
+				n = perBlock
+			}
+			off := int64(first) * pieceLength
+			length := int64(n) * pieceLength
+			if length > total-off {
+				length = total - off
+			}
+			var buf []byte
+			select {
+			case buf = <-free:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if int64(cap(buf)) < length {
+				buf = make([]byte, length)
+			}
+			buf = buf[:length]
+			// A multifile.File returns short reads at the ends of files.
+			var sr io.Reader = io.NewSectionReader(r, off, length)
+			if limiter != nil {
+				sr = iox.NewLimitedReader(ctx, sr, limiter)
+			}
+			_, err := io.ReadFull(sr, buf)
+			if err != nil {
+				return err
+			}
+			select {
+			case blocks <- block{first, buf}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// deviceSpans returns, for each device, the ranges of pieces to be read from it, where the device of a piece is
+// that of the file holding its first byte. devices holds the device of each file of info, or is nil if there is
+// only one. Pieces with non-nil entries in skip are left out.
+func deviceSpans(info *bencode.InfoDict, devices []uint64, skip [][]byte) [][]span {
+	n := numPieces(info)
+	if devices == nil {
+		devices = make([]uint64, len(info.Files)+1)
+	}
+	index := make(map[uint64]int)
+	var spans [][]span
+	var file int
+	var offset int64
+	for i := 0; i < n; i++ {
+		if i < len(skip) && skip[i] != nil {
+			continue
+		}
+		start := int64(i) * info.PieceLength
+		for file < len(info.Files)-1 && offset+info.Files[file].Length <= start {
+			offset += info.Files[file].Length
+			file++
+		}
+		d, ok := index[devices[file]]
+		if !ok {
+			d = len(spans)
+			index[devices[file]] = d
+			spans = append(spans, nil)
+		}
+		if s := spans[d]; len(s) > 0 && s[len(s)-1].end == i {
+			s[len(s)-1].end++
+		} else {
+			spans[d] = append(s, span{i, i + 1})
+		}
+	}
+	return spans
+}
+
+// fileDevices returns the device holding each of the files at paths, relative to dir. Files with nil paths, which
+// are not read from disk, take the device of the file before them.
+func fileDevices(dir string, paths [][]string) ([]uint64, error) {
+	devices := make([]uint64, len(paths))
+	for n, path := range paths {
+		if path == nil {
+			if n > 0 {
+				devices[n] = devices[n-1]
+			}
+			continue
+		}
+		d, err := device(filepath.Join(dir, filepath.Join(path...)))
+		if err != nil {
+			return nil, err
+		}
+		devices[n] = d
+	}
+	return devices, nil
+}
@@ -0,0 +1,43 @@
+package maketorrent
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+
+	"github.com/takeyourhatoff/bt/bencode"
+)
+
+// Magnet returns a magnet link to m, giving its infohashes, name, trackers and web seeds.
+func Magnet(m bencode.Metainfo) string {
+	var params []string
+	if m.Info.MetaVersion != 2 || len(m.Info.RawPieces) > 0 {
+		params = append(params, "xt=urn:btih:"+hex.EncodeToString(m.Info.Infohash(sha1.New())))
+	}
+	if m.Info.MetaVersion == 2 {
+		// A multihash, whose prefix identifies a 32 byte SHA-256 hash.
+		params = append(params, "xt=urn:btmh:1220"+hex.EncodeToString(m.Info.Infohash(sha256.New())))
+	}
+	params = append(params, "dn="+escape(m.Info.Name))
+	seen := make(map[string]bool)
+	trackers := []string{m.Announce}
+	for _, tier := range m.AnnounceList {
+		trackers = append(trackers, tier...)
+	}
+	for _, tr := range trackers {
+		if tr != "" && !seen[tr] {
+			seen[tr] = true
+			params = append(params, "tr="+escape(tr))
+		}
+	}
+	for _, ws := range m.URLList {
+		params = append(params, "ws="+escape(ws))
+	}
+	return "magnet:?" + strings.Join(params, "&")
+}
+
+func escape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
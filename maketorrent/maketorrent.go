@@ -0,0 +1,639 @@
+// Package maketorrent creates torrents from files on disk.
+package maketorrent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/takeyourhatoff/bt/bencode"
+	"github.com/takeyourhatoff/bt/internal/multifile"
+)
+
+const (
+	// MinPieceLength and MaxPieceLength bound the piece length, whether given or chosen automatically.
+	MinPieceLength = 16 << 10
+	MaxPieceLength = 64 << 20
+
+	// maxAutoPieceLength is the largest piece length chosen automatically, as larger pieces are poorly supported
+	// by clients.
+	maxAutoPieceLength = 16 << 20
+
+	// DefaultTargetPieces is the number of pieces aimed for when the piece length is chosen automatically.
+	DefaultTargetPieces = 1500
+)
+
+// Builder holds the options for creating a torrent. The zero value creates a public torrent with no trackers and
+// a piece length chosen automatically.
+type Builder struct {
+	// PieceLength must be a power of two between MinPieceLength and MaxPieceLength, or 0 to choose the piece length
+	// from the total size with PieceLengthFor.
+	PieceLength int64
+
+	// TargetPieces is the number of pieces aimed for when the piece length is chosen automatically, or 0 for
+	// DefaultTargetPieces. If TargetSize is not 0 it takes precedence, and the piece length is chosen so that the
+	// piece hashes fit in about TargetSize bytes of the .torrent, or are a single hash if it is smaller than one.
+	TargetPieces int
+	TargetSize   int64
+
+	Trackers  [][]string // tiers of announce urls
+	WebSeeds  []string
+	Private   bool
+	Source    string // changes the infohash, so that private trackers can tell cross-seeded torrents apart
+	Comment   string
+	CreatedBy string // the name and version of the program creating the torrent
+
+	// Format is the version of the torrent to create.
+	Format Format
+
+	// Align inserts BEP 47 padding files so that every file starts on a piece boundary. It is implied by the V2 and
+	// Hybrid formats.
+	Align bool
+
+	// Files and directories below the root whose names match any of the Exclude patterns, or which are hidden and
+	// SkipHidden is set, are left out of the torrent, as are files which match none of the Include patterns if there
+	// are any. Patterns are those of path.Match, and are matched against the slash separated path relative to the
+	// root if they contain a slash, or else against the name alone. Names starting with a dot are hidden.
+	Include    []string
+	Exclude    []string
+	SkipHidden bool
+
+	// Filter, if not nil, is called with the path relative to the root, and the FileInfo, of every file and
+	// directory below the root which is not already excluded. Those for which it returns false are left out of the
+	// torrent.
+	Filter func(path string, info os.FileInfo) bool
+
+	// Symlinks says what to do with symbolic links below the root. A root which is itself a symlink is always
+	// followed.
+	Symlinks SymlinkPolicy
+
+	// Previous, if not nil, is an earlier torrent of the same files, whose piece hashes are reused where the pieces
+	// are made of files with the same paths and lengths which were last modified before PreviousTime. PreviousTime
+	// must be no later than when hashing of Previous began, such as the creation date given to it by Build. Reuse
+	// trusts the sizes and modification times of files: on filesystems which record modification times coarsely,
+	// such as FAT or some network filesystems, a file rewritten to the same size soon after it was hashed can keep
+	// its stale hashes. Setting Verify hashes the reused pieces anyway, and fails if any has changed, which should
+	// be done on such filesystems. Previous can only be given for V1 torrents.
+	Previous     *bencode.InfoDict
+	PreviousTime time.Time
+	Verify       bool
+
+	// Checkpoint, if not empty, names a file to which the hashes computed so far are saved every
+	// CheckpointInterval, or DefaultCheckpointInterval if it is 0, and when hashing fails, is cancelled or is done.
+	// It is kept once the torrent is built, so that nothing is lost if the torrent cannot then be written, and should
+	// be removed with RemoveCheckpoint once it has been. If Resume is set and the file exists, hashing resumes from
+	// it, provided it was saved from the same files with the same modification times, or else Build fails with
+	// ErrStaleCheckpoint. Only V1 torrents can be checkpointed.
+	Checkpoint         string
+	CheckpointInterval time.Duration
+	Resume             bool
+
+	// Pool, if not nil, is shared with other Builders to bound the hashing they do at once. Otherwise the torrent is
+	// hashed with one hasher per CPU and files are read as fast as possible.
+	Pool *Pool
+
+	// Progress, if not nil, is called after each piece is hashed with the number of bytes hashed so far and the
+	// total to be hashed. Calls are never concurrent.
+	Progress func(hashed, total int64)
+}
+
+// Build creates a torrent of the file or directory at root. The returned Metainfo is dated when Build began,
+// truncated to the second, for use as the PreviousTime of a later Build. Names are NFC normalised and files are
+// ordered by name, so that the same tree gives the same torrent on any system.
+func (b *Builder) Build(ctx context.Context, root string) (bencode.Metainfo, error) {
+	start := time.Now().Truncate(time.Second)
+	m, paths, mtimes, err := b.layout(root)
+	if err != nil {
+		return m, err
+	}
+	f, err := multifile.OpenPaths(filepath.Dir(root), m, paths)
+	if err != nil {
+		return m, err
+	}
+	defer f.Close()
+	var devices []uint64
+	if m.Info.Length == 0 {
+		devices, err = fileDevices(filepath.Dir(root), paths)
+		if err != nil {
+			return m, err
+		}
+	}
+	j := &hashJob{
+		info:    &m.Info,
+		devices: devices,
+		v1:      b.Format != V2,
+		verify:  b.Verify,
+		v2:      b.Format != V1,
+	}
+	if (b.Previous != nil || b.Checkpoint != "") && b.Format != V1 {
+		return m, errors.New("previous torrents and checkpoints can only be used for v1 torrents")
+	}
+	if b.Previous != nil {
+		j.known = reuse(&m.Info, mtimes, b.Previous, b.PreviousTime)
+	}
+	if b.Checkpoint != "" {
+		c := newCheckpoint(&m.Info, mtimes)
+		if b.Resume {
+			j.known, err = c.resume(b.Checkpoint, j.known, numPieces(&m.Info))
+			if err != nil {
+				return m, err
+			}
+		}
+		j.save = func(p [][]byte) error { return c.write(b.Checkpoint, p) }
+	}
+	h, h2, err := b.pieces(ctx, f, j)
+	if err != nil {
+		if j.save != nil && h != nil {
+			if err0 := j.save(h); err0 != nil {
+				return m, errors.Errorf("%v (and %v)", err, err0)
+			}
+		}
+		return m, err
+	}
+	if j.save != nil {
+		err = j.save(h)
+		if err != nil {
+			return m, err
+		}
+	}
+	if j.v1 {
+		m.Info.RawPieces = bytes.Join(h, nil)
+	}
+	if j.v2 {
+		m.Info.MetaVersion = 2
+		m.Info.FileTree, m.PieceLayers = fileTree(&m.Info, h2)
+	}
+	if !j.v1 {
+		m.Info.Files, m.Info.Length = nil, 0
+	}
+	m.CreationDate = start
+	return m, nil
+}
+
+// RemoveCheckpoint removes the Checkpoint of b, if there is one, once the torrent it was saved from is no longer
+// needed.
+func (b *Builder) RemoveCheckpoint() error {
+	if b.Checkpoint == "" {
+		return nil
+	}
+	err := os.Remove(b.Checkpoint)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// layout returns the torrent of root without its hashes, in the v1 format with any padding files, along with the
+// paths and modification times of its files as returned by files.
+func (b *Builder) layout(root string) (bencode.Metainfo, [][]string, map[string]time.Time, error) {
+	var m bencode.Metainfo
+	if b.PieceLength != 0 {
+		err := ValidPieceLength(b.PieceLength)
+		if err != nil {
+			return m, nil, nil, err
+		}
+	}
+	m.Info.Name = norm.NFC.String(filepath.Base(root))
+	b.options(&m)
+
+	paths, mtimes, err := b.files(root, &m.Info)
+	if err != nil {
+		return m, nil, nil, err
+	}
+	m.Info.PieceLength = b.pieceLength(m.Info.TotalLength())
+	if (b.Align || b.Format != V1) && m.Info.Length == 0 {
+		m.Info.Files, paths = alignFiles(m.Info.Files, paths, m.Info.PieceLength)
+	}
+	return m, paths, mtimes, nil
+}
+
+// options fills in the fields of m which are set by the options of b rather than by the files.
+func (b *Builder) options(m *bencode.Metainfo) {
+	m.Info.Private = b.Private
+	m.Info.Source = b.Source
+	m.Comment = b.Comment
+	m.CreatedBy = b.CreatedBy
+	m.URLList = b.WebSeeds
+	var trackers int
+	for _, tier := range b.Trackers {
+		if len(tier) > 0 && m.Announce == "" {
+			m.Announce = tier[0]
+		}
+		trackers += len(tier)
+	}
+	if trackers > 1 {
+		m.AnnounceList = b.Trackers
+	}
+}
+
+// pieceLength returns the piece length of a torrent of total bytes, before any padding.
+func (b *Builder) pieceLength(total int64) int64 {
+	if b.PieceLength != 0 {
+		return b.PieceLength
+	}
+	target := b.TargetPieces
+	if b.TargetSize != 0 {
+		target = int(b.TargetSize / sha1.Size)
+		if target < 1 {
+			target = 1
+		}
+	}
+	return PieceLengthFor(total, target)
+}
+
+// Matches reports whether m is the torrent which b would build of root, other than its hashes and creation date: it
+// has the same trackers, web seeds, flags, format and piece length, and the same files, with the same padding and
+// symlinks. The files are listed but not read, so a torrent whose files have since changed in place still matches.
+func (b *Builder) Matches(m bencode.Metainfo, root string) (bool, error) {
+	want, _, _, err := b.layout(root)
+	if err != nil {
+		return false, err
+	}
+	if m.Info.Name != want.Info.Name || m.Info.PieceLength != want.Info.PieceLength ||
+		m.Info.Private != want.Info.Private || m.Info.Source != want.Info.Source || m.Comment != want.Comment ||
+		m.CreatedBy != want.CreatedBy || m.Announce != want.Announce ||
+		!equalStrings(m.URLList, want.URLList) || len(m.AnnounceList) != len(want.AnnounceList) {
+		return false, nil
+	}
+	for n, tier := range m.AnnounceList {
+		if !equalStrings(tier, want.AnnounceList[n]) {
+			return false, nil
+		}
+	}
+
+	v1, v2 := len(m.Info.RawPieces) > 0, m.Info.MetaVersion == 2
+	if v1 != (b.Format != V2) || v2 != (b.Format != V1) {
+		return false, nil
+	}
+	files, wantFiles := m.Info.FileTree.Files(), fileList(&want.Info)
+	if v1 {
+		files = fileList(&m.Info)
+	} else {
+		// The file tree of a v2 torrent has no padding files.
+		var unpadded []bencode.File
+		for _, f := range wantFiles {
+			if !f.IsPadding() {
+				unpadded = append(unpadded, f)
+			}
+		}
+		wantFiles = unpadded
+	}
+	if len(files) != len(wantFiles) {
+		return false, nil
+	}
+	for n, f := range files {
+		w := wantFiles[n]
+		if f.Length != w.Length || f.Attr != w.Attr || !equalStrings(f.Path, w.Path) ||
+			!equalStrings(f.SymlinkPath, w.SymlinkPath) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// files fills in the files of info from the file or directory at root, returning the paths of the files on disk,
+// which are read verbatim, and their modification times keyed by their slash separated paths in the torrent.
+func (b *Builder) files(root string, info *bencode.InfoDict) ([][]string, map[string]time.Time, error) {
+	for _, p := range append(b.Include, b.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, nil, errors.Wrapf(err, "pattern %q", p)
+		}
+	}
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !fi.IsDir() {
+		info.Length = fi.Size()
+		return [][]string{{filepath.Base(root)}}, map[string]time.Time{info.Name: fi.ModTime()}, nil
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	w := walker{b: b, root: abs, name: filepath.Base(root), info: info, follow: make(map[string]bool)}
+	for {
+		info.Files, w.paths, w.mtimes = nil, nil, make(map[string]time.Time)
+		err = w.walk(abs, nil, nil, []os.FileInfo{fi})
+		if err != nil {
+			return nil, nil, err
+		}
+		// Stored links whose targets turned out not to be in the torrent, because they are dangling or were left
+		// out, are followed instead. Following them may leave out the targets of others, so walk again until
+		// every stored link points into the torrent.
+		unresolved := w.unresolved()
+		if len(unresolved) == 0 {
+			break
+		}
+		for _, rel := range unresolved {
+			w.follow[rel] = true
+		}
+	}
+	if len(info.Files) == 0 {
+		return nil, nil, errors.Errorf("%s contains no files", root)
+	}
+	return w.paths, w.mtimes, nil
+}
+
+// SymlinkPolicy says what to do with symbolic links below the root of a torrent.
+type SymlinkPolicy int
+
+const (
+	FollowSymlinks SymlinkPolicy = iota // include the file or directory the link points to
+	SkipSymlinks                        // leave the link out
+	// StoreSymlinks stores the link as a BEP 47 symlink if it points to a file or directory in the torrent, skips it
+	// if it points to the root, and otherwise follows it.
+	StoreSymlinks
+)
+
+var symlinkPolicies = []string{"follow", "skip", "store"}
+
+func (p SymlinkPolicy) String() string {
+	if p < 0 || int(p) >= len(symlinkPolicies) {
+		return "SymlinkPolicy(" + strconv.Itoa(int(p)) + ")"
+	}
+	return symlinkPolicies[p]
+}
+
+// Set sets p from its name, so that a SymlinkPolicy may be used as a flag.
+func (p *SymlinkPolicy) Set(s string) error {
+	for n, name := range symlinkPolicies {
+		if s == name {
+			*p = SymlinkPolicy(n)
+			return nil
+		}
+	}
+	return errors.Errorf("invalid symlink policy %q, expected one of %s", s, strings.Join(symlinkPolicies, ", "))
+}
+
+// DefaultExclude matches files which are rarely wanted in a torrent: operating system metadata, editor backup and
+// swap files, and version control directories.
+var DefaultExclude = []string{
+	".DS_Store", "._*", "Thumbs.db", "desktop.ini",
+	"*~", "*.swp", "*.swo", ".#*",
+	".git", ".hg", ".svn", ".bzr", "CVS",
+}
+
+// include returns whether the file or directory with the given path relative to the root should be in the torrent.
+func (b *Builder) include(rel []string, fi os.FileInfo) bool {
+	slashed := strings.Join(rel, "/")
+	if b.SkipHidden && strings.HasPrefix(rel[len(rel)-1], ".") {
+		return false
+	}
+	for _, p := range b.Exclude {
+		if match(p, slashed) {
+			return false
+		}
+	}
+	if !fi.IsDir() && len(b.Include) > 0 {
+		var ok bool
+		for _, p := range b.Include {
+			ok = ok || match(p, slashed)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return b.Filter == nil || b.Filter(filepath.FromSlash(slashed), fi)
+}
+
+// match reports whether the slash separated path matches pattern. A pattern containing a slash is matched against
+// the whole path, and any other pattern against the last element.
+func match(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+type walker struct {
+	b      *Builder
+	root   string // absolute
+	name   string // the name of the root as given to Build
+	info   *bencode.InfoDict
+	paths  [][]string
+	mtimes map[string]time.Time
+	follow map[string]bool // slash separated paths of links to follow rather than store
+}
+
+// walk adds the files below the directory dir, ordered by the bytes of their NFC normalised names. rel is the path of
+// dir relative to the root as it appears in the torrent, and disk holds the on-disk spelling of rel. ancestors are dir
+// and the directories containing it, so that cycles of symlinks are found.
+func (w *walker) walk(dir string, rel, disk []string, ancestors []os.FileInfo) error {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	names := make([]string, len(fis))
+	for n, fi := range fis {
+		names[n] = norm.NFC.String(fi.Name())
+	}
+	sort.Sort(byName{fis, names})
+	for n, fi := range fis {
+		if n > 0 && names[n] == names[n-1] {
+			return errors.Errorf("%s: %q and %q have the same normalised name", dir, fis[n-1].Name(), fi.Name())
+		}
+		name := filepath.Join(dir, fi.Name())
+		rel := append(rel[:len(rel):len(rel)], names[n])
+		disk := append(disk[:len(disk):len(disk)], fi.Name())
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if w.b.Symlinks == SkipSymlinks {
+				continue
+			}
+			if w.b.Symlinks == StoreSymlinks && !w.follow[strings.Join(rel, "/")] {
+				target, err := w.symlinkTarget(name)
+				if err != nil {
+					return err
+				}
+				if target != nil && len(target) == 0 {
+					// A link to the root can be neither stored, as it has no path in the torrent, nor followed.
+					continue
+				}
+				if target != nil {
+					if w.b.include(rel, fi) {
+						w.info.Files = append(w.info.Files, bencode.File{Attr: "l", Path: rel, SymlinkPath: target})
+						w.paths = append(w.paths, nil)
+					}
+					continue
+				}
+			}
+			fi, err = os.Stat(name)
+			if err != nil {
+				return err
+			}
+		}
+		if !w.b.include(rel, fi) {
+			continue
+		}
+		if fi.IsDir() {
+			for _, a := range ancestors {
+				if os.SameFile(a, fi) {
+					return errors.Errorf("%s: symlink cycle", name)
+				}
+			}
+			err := w.walk(name, rel, disk, append(ancestors[:len(ancestors):len(ancestors)], fi))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		w.info.Files = append(w.info.Files, bencode.File{Length: fi.Size(), Path: rel})
+		w.mtimes[strings.Join(rel, "/")] = fi.ModTime()
+		w.paths = append(w.paths, append([]string{w.name}, disk...))
+	}
+	return nil
+}
+
+// byName sorts FileInfos by their normalised names.
+type byName struct {
+	fis   []os.FileInfo
+	names []string
+}
+
+func (s byName) Len() int           { return len(s.fis) }
+func (s byName) Less(i, j int) bool { return s.names[i] < s.names[j] }
+func (s byName) Swap(i, j int) {
+	s.fis[i], s.fis[j] = s.fis[j], s.fis[i]
+	s.names[i], s.names[j] = s.names[j], s.names[i]
+}
+
+// symlinkTarget returns the target of the symlink name as a path relative to the root, which is empty if it points
+// to the root itself, or nil if it points outside the root.
+func (w *walker) symlinkTarget(name string) ([]string, error) {
+	target, err := os.Readlink(name)
+	if err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(name), target)
+	}
+	rel, err := filepath.Rel(w.root, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, nil
+	}
+	if rel == "." {
+		return []string{}, nil
+	}
+	return strings.Split(norm.NFC.String(filepath.ToSlash(rel)), "/"), nil
+}
+
+// unresolved returns the slash separated paths of the stored links whose targets are not a file of the torrent, or a
+// directory containing one, other than the link itself.
+func (w *walker) unresolved() []string {
+	var out []string
+	for n, f := range w.info.Files {
+		if !f.IsSymlink() {
+			continue
+		}
+		var found bool
+		for k, g := range w.info.Files {
+			if k != n && len(g.Path) >= len(f.SymlinkPath) && equalStrings(g.Path[:len(f.SymlinkPath)], f.SymlinkPath) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			out = append(out, strings.Join(f.Path, "/"))
+		}
+	}
+	return out
+}
+
+// ValidPieceLength returns an error if n is not a power of two between MinPieceLength and MaxPieceLength.
+func ValidPieceLength(n int64) error {
+	if n < MinPieceLength || n > MaxPieceLength || n&(n-1) != 0 {
+		return errors.Errorf("piece length %d is not a power of two between %d and %d", n, MinPieceLength, MaxPieceLength)
+	}
+	return nil
+}
+
+// PieceLengthFor returns the smallest power of two piece length which splits total bytes into no more than target
+// pieces, or DefaultTargetPieces if target is not positive. The piece length is at least MinPieceLength and at most
+// 16MiB, so very small or very large torrents have more or fewer pieces than the target.
+func PieceLengthFor(total int64, target int) int64 {
+	if target <= 0 {
+		target = DefaultTargetPieces
+	}
+	n := int64(MinPieceLength)
+	for n < maxAutoPieceLength && (total+n-1)/n > int64(target) {
+		n *= 2
+	}
+	return n
+}
+
+// alignFiles inserts BEP 47 padding files so that every file starts on a piece boundary.
+// The returned paths are nil for the padding files, which are not stored on disk.
+func alignFiles(files []bencode.File, paths [][]string, pieceLength int64) ([]bencode.File, [][]string) {
+	var files0 []bencode.File
+	var paths0 [][]string
+	var offset int64
+	for n, f := range files {
+		if pad := -offset & (pieceLength - 1); pad != 0 && f.Length > 0 {
+			files0 = append(files0, bencode.File{
+				Attr:   "p",
+				Length: pad,
+				Path:   []string{".pad", strconv.FormatInt(pad, 10)},
+			})
+			paths0 = append(paths0, nil)
+			offset += pad
+		}
+		files0 = append(files0, f)
+		paths0 = append(paths0, paths[n])
+		offset += f.Length
+	}
+	return files0, paths0
+}
+
+// ReadFile reads the torrent in the named file.
+func ReadFile(name string) (bencode.Metainfo, error) {
+	var m bencode.Metainfo
+	f, err := os.Open(name)
+	if err != nil {
+		return m, err
+	}
+	defer f.Close()
+	err = bencode.Decode(f, &m)
+	return m, errors.Wrapf(err, "reading %s", name)
+}
+
+// WriteFile writes the torrent m to the named file.
+func WriteFile(name string, m bencode.Metainfo) (err error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err0 := f.Close(); err == nil {
+			err = err0
+		}
+	}()
+	err = bencode.Encode(f, m)
+	return
+}
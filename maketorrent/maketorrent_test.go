@@ -0,0 +1,825 @@
+package maketorrent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/takeyourhatoff/bt/bencode"
+	"github.com/takeyourhatoff/bt/internal/multifile"
+	"github.com/takeyourhatoff/bt/internal/piece"
+)
+
+// tempTree creates a directory containing files with the given names and lengths of random data, which is the same
+// for the same files.
+func tempTree(t testing.TB, files map[string]int) string {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "root")
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	rng := rand.New(rand.NewSource(1))
+	for _, name := range names {
+		length := files[name]
+		name = filepath.Join(root, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(name), 0775)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, length)
+		rng.Read(data)
+		err = ioutil.WriteFile(name, data, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// verify checks that every piece of m matches the data in dir.
+func verify(t *testing.T, dir string, m bencode.Metainfo) {
+	f, err := multifile.Open(dir, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s := piece.NewStore(f, m.Info)
+	for n := 0; n < s.NumPieces(); n++ {
+		if err := s.VerifyPiece(n); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	root := tempTree(t, map[string]int{
+		"a":      100000,
+		"b":      5,
+		"c":      0,
+		".skip":  10,
+		"d":      70000,
+		"e/f":    10,
+		"e/skip": 10,
+	})
+	defer os.RemoveAll(filepath.Dir(root))
+	var calls int
+	var last int64
+	b := Builder{
+		PieceLength: 1 << 14,
+		Trackers:    [][]string{{"http://a/announce", "http://b/announce"}, {"udp://c:80"}},
+		WebSeeds:    []string{"http://seed/"},
+		Source:      "src",
+		Align:       true,
+		Filter: func(path string, info os.FileInfo) bool {
+			return path[0] != '.' && !info.IsDir()
+		},
+		Progress: func(hashed, total int64) {
+			calls++
+			if hashed < last || hashed > total {
+				t.Errorf("progress went from %d to %d of %d", last, hashed, total)
+			}
+			last = hashed
+		},
+	}
+	m, err := b.Build(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Announce != "http://a/announce" || len(m.AnnounceList) != 2 || m.Info.Source != "src" || len(m.URLList) != 1 {
+		t.Errorf("options not applied: %+v", m)
+	}
+	var names []string
+	for _, f := range m.Info.Files {
+		if !f.IsPadding() {
+			names = append(names, f.Path[0])
+		}
+	}
+	if want := []string{"a", "b", "c", "d"}; len(names) != len(want) {
+		t.Errorf("files = %q, expected %q", names, want)
+	}
+	if calls != m.Info.NumPieces() || last != m.Info.TotalLength() {
+		t.Errorf("progress called %d times reaching %d, expected %d times reaching %d", calls, last, m.Info.NumPieces(), m.Info.TotalLength())
+	}
+	verify(t, filepath.Dir(root), m)
+}
+
+// torrentPaths returns the slash separated paths of the files of m.
+func torrentPaths(m bencode.Metainfo) []string {
+	var paths []string
+	for _, f := range m.Info.Files {
+		paths = append(paths, strings.Join(f.Path, "/"))
+	}
+	return paths
+}
+
+func TestBuild_Filter(t *testing.T) {
+	root := tempTree(t, map[string]int{
+		"a/b/c/deep.txt":  10,
+		"a/b/keep.txt":    10,
+		"a/b/skip.log":    10,
+		"a/.hidden/x.txt": 10,
+		"a/.x.txt":        10,
+		"a/tmp/y.txt":     10,
+		".git/config":     10,
+		".DS_Store":       10,
+		"notes.txt~":      10,
+		"top.txt":         10,
+	})
+	defer os.RemoveAll(filepath.Dir(root))
+	b := Builder{
+		Include:    []string{"*.txt"},
+		Exclude:    append([]string{"a/tmp"}, DefaultExclude...),
+		SkipHidden: true,
+	}
+	m, err := b.Build(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a/b/c/deep.txt", "a/b/keep.txt", "top.txt"}
+	if got := torrentPaths(m); !reflect.DeepEqual(got, want) {
+		t.Errorf("paths = %q, expected %q", got, want)
+	}
+	verify(t, filepath.Dir(root), m)
+
+	b = Builder{Exclude: []string{"["}}
+	if _, err := b.Build(context.Background(), root); err == nil {
+		t.Error("Build with an invalid pattern succeeded")
+	}
+}
+
+func TestBuild_Symlinks(t *testing.T) {
+	root := tempTree(t, map[string]int{"d/file": 10})
+	defer os.RemoveAll(filepath.Dir(root))
+	outside := tempTree(t, map[string]int{"out": 20})
+	defer os.RemoveAll(filepath.Dir(outside))
+	links := map[string]string{
+		"d/rel":   "file",
+		"abs":     filepath.Join(root, "d", "file"),
+		"dirlink": "d",
+		"outside": filepath.Join(outside, "out"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Skip(err)
+		}
+	}
+	tests := []struct {
+		policy SymlinkPolicy
+		want   []string
+	}{
+		{FollowSymlinks, []string{"abs", "d/file", "d/rel", "dirlink/file", "dirlink/rel", "outside"}},
+		{SkipSymlinks, []string{"d/file"}},
+		{StoreSymlinks, []string{"abs", "d/file", "d/rel", "dirlink", "outside"}},
+	}
+	for _, tt := range tests {
+		b := Builder{Symlinks: tt.policy}
+		m, err := b.Build(context.Background(), root)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.policy, err)
+		}
+		if got := torrentPaths(m); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: paths = %q, expected %q", tt.policy, got, tt.want)
+		}
+		verify(t, filepath.Dir(root), m)
+		if tt.policy != StoreSymlinks {
+			continue
+		}
+		targets := map[string]string{"abs": "d/file", "d/rel": "d/file", "dirlink": "d"}
+		for _, f := range m.Info.Files {
+			want, ok := targets[strings.Join(f.Path, "/")]
+			if ok != f.IsSymlink() || ok && strings.Join(f.SymlinkPath, "/") != want {
+				t.Errorf("%q has attr %q and symlink path %q, expected %q", f.Path, f.Attr, f.SymlinkPath, want)
+			}
+		}
+	}
+
+	if err := os.Symlink("..", filepath.Join(root, "d", "cycle")); err != nil {
+		t.Fatal(err)
+	}
+	var b Builder
+	if _, err := b.Build(context.Background(), root); err == nil {
+		t.Error("Build following a symlink cycle succeeded")
+	}
+}
+
+func TestBuild_StoreSymlinks(t *testing.T) {
+	root := tempTree(t, map[string]int{".git/HEAD": 5, "a": 3, "d/file": 10})
+	defer os.RemoveAll(filepath.Dir(root))
+	links := map[string]string{
+		"head":    ".git/HEAD",    // left out, so followed
+		"good":    "d/file",       // stored
+		"dirlink": "d",            // stored
+		"chain":   "dirlink/file", // through a stored link, so followed
+		"self":    ".",            // the root, so skipped
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skip(err)
+		}
+	}
+	b := Builder{Symlinks: StoreSymlinks, Exclude: []string{".git"}}
+	m, err := b.Build(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a", "chain", "d/file", "dirlink", "good", "head"}
+	if got := torrentPaths(m); !reflect.DeepEqual(got, want) {
+		t.Errorf("paths = %q, expected %q", got, want)
+	}
+	verify(t, filepath.Dir(root), m)
+
+	// The torrent can be downloaded, with its links pointing at their targets.
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f, err := multifile.Create(dir, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	for name, want := range map[string]string{"good": "d/file", "dirlink": "d"} {
+		if got, err := os.Readlink(filepath.Join(dir, "root", name)); err != nil || got != filepath.FromSlash(want) {
+			t.Errorf("link %s points to %q, %v, expected %q", name, got, err, want)
+		}
+	}
+
+	// A dangling link cannot be followed.
+	if err := os.Symlink("nothere", filepath.Join(root, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Build(context.Background(), root); err == nil {
+		t.Error("Build with a dangling symlink succeeded")
+	}
+}
+
+func TestBuild_Reproducible(t *testing.T) {
+	encode := func(files map[string]int) []byte {
+		root := tempTree(t, files)
+		defer os.RemoveAll(filepath.Dir(root))
+		b := Builder{Trackers: [][]string{{"http://a/announce"}}}
+		m, err := b.Build(context.Background(), root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.CreationDate = time.Time{}
+		var buf bytes.Buffer
+		if err := bencode.Encode(&buf, m); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	// The same names, once NFC and once NFD, as macOS would return them.
+	nfc := encode(map[string]int{"caf\u00e9/b": 10, "Z": 10, "a": 10, "caf\u00e9/a": 10})
+	nfd := encode(map[string]int{"cafe\u0301/b": 10, "Z": 10, "a": 10, "cafe\u0301/a": 10})
+	if !bytes.Equal(nfc, nfd) {
+		t.Errorf("torrents differ:\n%q\n%q", nfc, nfd)
+	}
+
+	root := tempTree(t, map[string]int{"caf\u00e9": 10, "cafe\u0301": 10})
+	defer os.RemoveAll(filepath.Dir(root))
+	var b Builder
+	if _, err := b.Build(context.Background(), root); err == nil {
+		t.Error("Build with names which are the same when normalised succeeded")
+	}
+}
+
+func TestBuild_Reuse(t *testing.T) {
+	root := tempTree(t, map[string]int{"a": 50000, "b": 40000, "c": 30000})
+	defer os.RemoveAll(filepath.Dir(root))
+	old := time.Now().Add(-time.Hour)
+	for _, name := range []string{"a", "b", "c"} {
+		if err := os.Chtimes(filepath.Join(root, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := Builder{PieceLength: MinPieceLength, Align: true}
+	prev, err := b.Build(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Change b without changing its size, and add a file at the end.
+	data := make([]byte, 40000)
+	if err := ioutil.WriteFile(filepath.Join(root, "b"), data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "d"), data[:100], 0666); err != nil {
+		t.Fatal(err)
+	}
+	want, err := b.Build(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hashed int64
+	b.Previous, b.PreviousTime = &prev.Info, old.Add(time.Minute)
+	b.Progress = func(n, total int64) { hashed = total }
+	got, err := b.Build(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Info.RawPieces, want.Info.RawPieces) {
+		t.Error("reusing hashes gave different pieces from hashing everything")
+	}
+	// Only b, padded to 3 pieces, the last piece of c, which gains padding, and d need hashing.
+	if hashed != 4*MinPieceLength+100 {
+		t.Errorf("hashed %d bytes, expected %d", hashed, 4*MinPieceLength+100)
+	}
+
+	// b appears unchanged but is not, which Verify notices.
+	if err := os.Chtimes(filepath.Join(root, "b"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	b.Verify = true
+	if _, err := b.Build(context.Background(), root); err == nil {
+		t.Error("Build with Verify succeeded when a reused piece had changed")
+	}
+
+	// A file modified in the second that hashing began may have changed after it was hashed.
+	for _, name := range []string{"a", "b", "c", "d"} {
+		if err := os.Chtimes(filepath.Join(root, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.Previous, b.Verify = nil, false
+	prev, err = b.Build(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(root, "a"), prev.CreationDate, prev.CreationDate); err != nil {
+		t.Fatal(err)
+	}
+	b.Previous, b.PreviousTime = &prev.Info, prev.CreationDate
+	if _, err := b.Build(context.Background(), root); err != nil {
+		t.Fatal(err)
+	}
+	if hashed != 4*MinPieceLength {
+		t.Errorf("hashed %d bytes, expected only the %d of a", hashed, 4*MinPieceLength)
+	}
+}
+
+func TestReuse_Shifted(t *testing.T) {
+	since := time.Now()
+	prev := &bencode.InfoDict{
+		PieceLength: 10,
+		RawPieces:   make([]byte, 4*sha1.Size),
+		Files:       []bencode.File{{Length: 20, Path: []string{"a"}}, {Length: 15, Path: []string{"b"}}},
+	}
+	for i := range prev.RawPieces {
+		prev.RawPieces[i] = byte(i / sha1.Size)
+	}
+	// A file inserted before b moves it off its pieces, but a is unaffected.
+	info := &bencode.InfoDict{
+		PieceLength: 10,
+		Files:       []bencode.File{{Length: 20, Path: []string{"a"}}, {Length: 5, Path: []string{"a2"}}, {Length: 15, Path: []string{"b"}}},
+	}
+	mtimes := map[string]time.Time{"a": since.Add(-time.Second), "a2": since.Add(-time.Second), "b": since.Add(-time.Second)}
+	known := reuse(info, mtimes, prev, since)
+	for i, h := range known {
+		if reused := h != nil; reused != (i < 2) {
+			t.Errorf("piece %d reused = %v", i, reused)
+		} else if reused && h[0] != byte(i) {
+			t.Errorf("piece %d reused the hash of piece %d", i, h[0])
+		}
+	}
+	// Swapping the files reuses only the piece which lines up with a whole piece of prev.
+	info.Files = []bencode.File{{Length: 15, Path: []string{"b"}}, {Length: 20, Path: []string{"a"}}}
+	known = reuse(info, mtimes, prev, since)
+	for i, h := range known {
+		if reused := h != nil; reused != (i == 0) {
+			t.Errorf("piece %d reused = %v", i, reused)
+		}
+	}
+	if known[0] != nil && known[0][0] != 2 {
+		t.Errorf("piece 0 reused the hash of piece %d, expected 2", known[0][0])
+	}
+	// Nothing is reused if b has been modified since.
+	mtimes["b"] = since
+	if known := reuse(info, mtimes, prev, since); known[0] != nil {
+		t.Error("piece 0 of a modified file reused")
+	}
+}
+
+func TestBuild_Checkpoint(t *testing.T) {
+	root := tempTree(t, map[string]int{"a": 100000, "b": 300000})
+	defer os.RemoveAll(filepath.Dir(root))
+	cp := filepath.Join(filepath.Dir(root), "checkpoint")
+	want, err := (&Builder{PieceLength: MinPieceLength}).Build(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Interrupt hashing after the first piece.
+	ctx, cancel := context.WithCancel(context.Background())
+	b := Builder{
+		PieceLength: MinPieceLength,
+		Checkpoint:  cp,
+		Progress:    func(hashed, total int64) { cancel() },
+	}
+	if _, err := b.Build(ctx, root); err != context.Canceled {
+		t.Fatalf("b.Build() = %v, expected %v", err, context.Canceled)
+	}
+	if _, err := os.Stat(cp); err != nil {
+		t.Fatal(err)
+	}
+
+	var toHash int64
+	b.Resume = true
+	b.Progress = func(hashed, total int64) { toHash = total }
+	got, err := b.Build(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Info.RawPieces, want.Info.RawPieces) {
+		t.Error("resuming gave different pieces from hashing everything")
+	}
+	if toHash >= want.Info.TotalLength() {
+		t.Errorf("hashed %d bytes after resuming, expected fewer than %d", toHash, want.Info.TotalLength())
+	}
+	// The checkpoint is kept, complete, until the torrent has been written.
+	toHash = -1
+	if _, err := b.Build(context.Background(), root); err != nil {
+		t.Fatal(err)
+	}
+	if toHash > 0 {
+		t.Errorf("hashed %d bytes after resuming from a complete checkpoint, expected none", toHash)
+	}
+	if err := b.RemoveCheckpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cp); !os.IsNotExist(err) {
+		t.Errorf("checkpoint not removed: %v", err)
+	}
+	if err := b.RemoveCheckpoint(); err != nil {
+		t.Errorf("removing a removed checkpoint: %v", err)
+	}
+
+	// A checkpoint of files which have since been modified is refused.
+	c := newCheckpoint(&want.Info, map[string]time.Time{"a": time.Unix(1, 0)})
+	if err := c.write(cp, want.Info.Pieces()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Build(context.Background(), root); errors.Cause(err) != ErrStaleCheckpoint {
+		t.Errorf("b.Build() = %v, expected %v", err, ErrStaleCheckpoint)
+	}
+}
+
+// piecesRoot returns the BEP 52 pieces root of data, computed directly from the leaves.
+func piecesRoot(data []byte) []byte {
+	var layer [][]byte
+	for off := 0; off < len(data); off += leafSize {
+		end := off + leafSize
+		if end > len(data) {
+			end = len(data)
+		}
+		h := sha256.Sum256(data[off:end])
+		layer = append(layer, h[:])
+	}
+	for len(layer) < nextPow2(len(layer)) {
+		layer = append(layer, make([]byte, sha256.Size))
+	}
+	for len(layer) > 1 {
+		var next [][]byte
+		for i := 0; i < len(layer); i += 2 {
+			h := sha256.Sum256(append(append([]byte(nil), layer[i]...), layer[i+1]...))
+			next = append(next, h[:])
+		}
+		layer = next
+	}
+	return layer[0]
+}
+
+func TestBuild_V2(t *testing.T) {
+	files := map[string]int{"a": 100000, "d/b": 16384, "d/c": 20000, "e": 0, "f": 1}
+	root := tempTree(t, files)
+	defer os.RemoveAll(filepath.Dir(root))
+	for _, format := range []Format{V2, Hybrid} {
+		b := Builder{PieceLength: 32 << 10, Format: format}
+		m, err := b.Build(context.Background(), root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Info.MetaVersion != 2 {
+			t.Errorf("meta version = %d, expected 2", m.Info.MetaVersion)
+		}
+		if format == V2 && (m.Info.RawPieces != nil || m.Info.Files != nil) {
+			t.Error("v2 torrent has v1 pieces or files")
+		}
+		if format == Hybrid {
+			verify(t, filepath.Dir(root), m)
+		}
+		for name, length := range files {
+			path := strings.Split(name, "/")
+			node := m.Info.FileTree
+			for _, dir := range path[:len(path)-1] {
+				node = node[dir].(bencode.FileTree)
+			}
+			f := node[path[len(path)-1]].(bencode.FileTree)[""].(bencode.V2File)
+			data, err := ioutil.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Length != int64(length) {
+				t.Errorf("%s has length %d, expected %d", name, f.Length, length)
+			}
+			if length == 0 {
+				if f.PiecesRoot != nil {
+					t.Errorf("empty file %s has a pieces root", name)
+				}
+				continue
+			}
+			if want := piecesRoot(data); !bytes.Equal(f.PiecesRoot, want) {
+				t.Errorf("%s has pieces root %x, expected %x", name, f.PiecesRoot, want)
+			}
+			layer, ok := m.PieceLayers[string(f.PiecesRoot)]
+			if wantLayer := int64(length) > b.PieceLength; ok != wantLayer {
+				t.Errorf("%s has piece layer %v, expected %v", name, ok, wantLayer)
+			}
+			if ok && len(layer) != (length+int(b.PieceLength)-1)/int(b.PieceLength)*sha256.Size {
+				t.Errorf("%s has a piece layer of %d bytes", name, len(layer))
+			}
+		}
+	}
+
+	for _, b := range []Builder{
+		{Format: V2, Checkpoint: filepath.Join(filepath.Dir(root), "checkpoint")},
+		{Format: Hybrid, Previous: &bencode.InfoDict{PieceLength: MinPieceLength}},
+	} {
+		if _, err := b.Build(context.Background(), root); err == nil {
+			t.Errorf("Build of a %v torrent with a checkpoint or previous torrent succeeded", b.Format)
+		}
+	}
+}
+
+func TestMagnet(t *testing.T) {
+	m := bencode.Metainfo{
+		Announce:     "http://a/announce",
+		AnnounceList: [][]string{{"http://a/announce", "http://b/announce?x=1&y=2"}, {"udp://c:80"}},
+		URLList:      []string{"http://seed/"},
+		Info:         bencode.InfoDict{Name: "my files", PieceLength: 16384, RawPieces: make([]byte, 20), Length: 1},
+	}
+	v1 := hex.EncodeToString(m.Info.Infohash(sha1.New()))
+	want := "magnet:?xt=urn:btih:" + v1 + "&dn=my%20files&tr=http%3A%2F%2Fa%2Fannounce" +
+		"&tr=http%3A%2F%2Fb%2Fannounce%3Fx%3D1%26y%3D2&tr=udp%3A%2F%2Fc%3A80&ws=http%3A%2F%2Fseed%2F"
+	if got := Magnet(m); got != want {
+		t.Errorf("Magnet() = %q, expected %q", got, want)
+	}
+
+	m = bencode.Metainfo{Info: bencode.InfoDict{Name: "x", PieceLength: 16384, MetaVersion: 2}}
+	v2 := hex.EncodeToString(m.Info.Infohash(sha256.New()))
+	if got, want := Magnet(m), "magnet:?xt=urn:btmh:1220"+v2+"&dn=x"; got != want {
+		t.Errorf("Magnet() = %q, expected %q", got, want)
+	}
+}
+
+func TestBuild_SingleFile(t *testing.T) {
+	root := tempTree(t, map[string]int{"file": 12345})
+	defer os.RemoveAll(filepath.Dir(root))
+	var b Builder
+	m, err := b.Build(context.Background(), filepath.Join(root, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Info.Length != 12345 || m.Info.Name != "file" || m.Info.PieceLength != MinPieceLength {
+		t.Errorf("unexpected info: %+v", m.Info)
+	}
+	verify(t, root, m)
+
+	for _, format := range []Format{Hybrid, V2} {
+		b := Builder{Format: format}
+		m, err := b.Build(context.Background(), filepath.Join(root, "file"))
+		if err != nil {
+			t.Fatalf("%v: %v", format, err)
+		}
+		f, _ := m.Info.FileTree["file"].(bencode.FileTree)[""].(bencode.V2File)
+		if len(m.Info.FileTree) != 1 || f.Length != 12345 || m.Info.Length != 12345 && format == Hybrid {
+			t.Errorf("%v: unexpected info: %+v", format, m.Info)
+		}
+	}
+}
+
+func TestBuild_InvalidPieceLength(t *testing.T) {
+	for _, n := range []int64{-1, 1 << 10, 3 << 20, 1 << 30} {
+		b := Builder{PieceLength: n}
+		if _, err := b.Build(context.Background(), "nonexistent"); err == nil {
+			t.Errorf("Build with piece length %d succeeded", n)
+		}
+	}
+}
+
+func TestPieceLengthFor(t *testing.T) {
+	tests := []struct {
+		total  int64
+		target int
+		want   int64
+	}{
+		{0, 0, MinPieceLength},
+		{50 << 10, 0, MinPieceLength},
+		{1500 << 20, 0, 1 << 20},
+		{1500<<20 + 1, 0, 2 << 20},
+		{1 << 30, 1 << 10, 1 << 20},
+		{2 << 40, 0, maxAutoPieceLength},
+	}
+	for _, tt := range tests {
+		if got := PieceLengthFor(tt.total, tt.target); got != tt.want {
+			t.Errorf("PieceLengthFor(%d, %d) = %d, expected %d", tt.total, tt.target, got, tt.want)
+		}
+		if err := ValidPieceLength(tt.want); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestBuilder_PieceLength(t *testing.T) {
+	tests := []struct {
+		b    Builder
+		want int64
+	}{
+		{Builder{}, 2 << 20},
+		{Builder{TargetPieces: 3000}, 1 << 20},
+		{Builder{TargetSize: 1500 * 20}, 2 << 20},
+		// Less than one hash is taken as one, not as no target at all.
+		{Builder{TargetSize: 1}, maxAutoPieceLength},
+		{Builder{TargetSize: 19, TargetPieces: 3000}, maxAutoPieceLength},
+		{Builder{PieceLength: MinPieceLength, TargetSize: 1}, MinPieceLength},
+	}
+	for _, tt := range tests {
+		if got := tt.b.pieceLength(2000 << 20); got != tt.want {
+			t.Errorf("pieceLength with pieces %d, size %d = %d, expected %d", tt.b.TargetPieces, tt.b.TargetSize, got, tt.want)
+		}
+	}
+}
+
+func TestBuild_Cancel(t *testing.T) {
+	root := tempTree(t, map[string]int{"a": 1 << 20})
+	defer os.RemoveAll(filepath.Dir(root))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b := Builder{PieceLength: MinPieceLength}
+	if _, err := b.Build(ctx, root); err != context.Canceled {
+		t.Errorf("b.Build() = %v, expected %v", err, context.Canceled)
+	}
+}
+
+func TestBuilder_Matches(t *testing.T) {
+	root := tempTree(t, map[string]int{"a": 100000, "b": 5, ".h": 5})
+	defer os.RemoveAll(filepath.Dir(root))
+	if err := os.Symlink("a", filepath.Join(root, "l")); err != nil {
+		t.Skip(err)
+	}
+	b := Builder{Trackers: [][]string{{"http://a/announce"}, {"http://b/announce"}}, Source: "src", Align: true}
+	for _, format := range []Format{V1, V2, Hybrid} {
+		b.Format = format
+		m, err := b.Build(context.Background(), root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := b.Matches(m, root); !ok || err != nil {
+			t.Errorf("%v: Matches() = %v, %v for the torrent it built", format, ok, err)
+		}
+		others := []Builder{
+			{Trackers: b.Trackers, Source: "src", Align: true, Format: (format + 1) % 3},
+			{Trackers: b.Trackers, Source: "other", Align: true, Format: format},
+			{Trackers: b.Trackers[:1], Source: "src", Align: true, Format: format},
+			{Trackers: b.Trackers, Source: "src", Align: true, Format: format, PieceLength: 1 << 20},
+			{Trackers: b.Trackers, Source: "src", Align: true, Format: format, TargetPieces: 2},
+			{Trackers: b.Trackers, Source: "src", Align: true, Format: format, Exclude: []string{"b"}},
+			{Trackers: b.Trackers, Source: "src", Align: true, Format: format, Include: []string{"a"}},
+			{Trackers: b.Trackers, Source: "src", Align: true, Format: format, SkipHidden: true},
+			{Trackers: b.Trackers, Source: "src", Align: true, Format: format, Symlinks: StoreSymlinks},
+		}
+		if format == V1 {
+			// Alignment is implied by the other formats.
+			others = append(others, Builder{Trackers: b.Trackers, Source: "src", Format: format})
+		}
+		for _, other := range others {
+			if ok, err := other.Matches(m, root); ok || err != nil {
+				t.Errorf("%v: Matches() = %v, %v with %+v", format, ok, err, other)
+			}
+		}
+	}
+	if _, err := b.Matches(bencode.Metainfo{}, filepath.Join(root, "missing")); err == nil {
+		t.Error("Matches() of a missing root succeeded")
+	}
+}
+
+func TestBuild_Pool(t *testing.T) {
+	roots := []string{
+		tempTree(t, map[string]int{"a": 300000, "b": 5}),
+		tempTree(t, map[string]int{"c": 200000, "d/e": 70000}),
+	}
+	for _, root := range roots {
+		defer os.RemoveAll(filepath.Dir(root))
+	}
+	pool := NewPool(1, 1<<30)
+	ms := make([]bencode.Metainfo, len(roots))
+	errs := make([]error, len(roots))
+	var wg sync.WaitGroup
+	for i, root := range roots {
+		wg.Add(1)
+		go func(i int, root string) {
+			defer wg.Done()
+			b := Builder{PieceLength: MinPieceLength, Pool: pool}
+			ms[i], errs[i] = b.Build(context.Background(), root)
+		}(i, root)
+	}
+	wg.Wait()
+	for i, root := range roots {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		verify(t, filepath.Dir(root), ms[i])
+	}
+}
+
+func TestAlignFiles(t *testing.T) {
+	files := []bencode.File{
+		{Length: 5, Path: []string{"a"}},
+		{Length: 0, Path: []string{"empty"}},
+		{Length: 16, Path: []string{"b"}},
+		{Length: 3, Path: []string{"c"}},
+	}
+	paths := [][]string{{"a"}, {"empty"}, {"b"}, {"c"}}
+	files, paths = alignFiles(files, paths, 16)
+	var offset int64
+	for n, f := range files {
+		if f.IsPadding() {
+			if paths[n] != nil {
+				t.Errorf("padding file %d has path %q", n, paths[n])
+			}
+		} else if f.Length > 0 && offset%16 != 0 {
+			t.Errorf("file %q starts at offset %d", f.Path, offset)
+		}
+		offset += f.Length
+	}
+	if offset != 16+16+3 {
+		t.Errorf("total length = %d, expected %d", offset, 16+16+3)
+	}
+}
+
+func TestDeviceSpans(t *testing.T) {
+	info := &bencode.InfoDict{
+		PieceLength: 10,
+		Files: []bencode.File{
+			{Length: 25}, // pieces 0-2 on device 1
+			{Length: 10}, // pieces 3 on device 2
+			{Length: 0},
+			{Length: 30}, // pieces 4-6 on device 1
+		},
+	}
+	got := deviceSpans(info, []uint64{1, 2, 2, 1}, nil)
+	want := [][]span{{{0, 3}, {4, 7}}, {{3, 4}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("deviceSpans() = %v, expected %v", got, want)
+	}
+	if got, want := deviceSpans(info, nil, nil), [][]span{{{0, 7}}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("deviceSpans() = %v, expected %v", got, want)
+	}
+	skip := [][]byte{5: {}}
+	if got, want := deviceSpans(info, nil, skip), [][]span{{{0, 5}, {6, 7}}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("deviceSpans() = %v, expected %v", got, want)
+	}
+}
+
+func BenchmarkBuild(b *testing.B) {
+	files := make(map[string]int)
+	var total int64
+	for i := 0; i < 16; i++ {
+		files[strconv.Itoa(i)] = 4<<20 + i
+		total += 4<<20 + int64(i)
+	}
+	root := tempTree(b, files)
+	defer os.RemoveAll(filepath.Dir(root))
+	for _, pieceLength := range []int64{MinPieceLength, 1 << 20, 16 << 20} {
+		b.Run(strconv.FormatInt(pieceLength>>10, 10)+"KiB", func(b *testing.B) {
+			b.SetBytes(total)
+			bl := Builder{PieceLength: pieceLength}
+			for i := 0; i < b.N; i++ {
+				if _, err := bl.Build(context.Background(), root); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
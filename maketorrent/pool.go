@@ -0,0 +1,52 @@
+package maketorrent
+
+import (
+	"context"
+	"runtime"
+
+	"github.com/takeyourhatoff/bt/internal/iox"
+)
+
+// Pool bounds the hashing done by the Builders which share it, so that many torrents can be created at once without
+// running more hashers than there are CPUs, or reading faster than the disks should be read. A Pool is safe for
+// concurrent use.
+type Pool struct {
+	workers int
+	tokens  chan struct{}
+	limiter *iox.Limiter
+}
+
+// NewPool returns a Pool which lets up to workers pieces be hashed at once, or one per CPU if workers <= 0, and which
+// reads files at no more than readRate bytes per second, or as fast as possible if readRate <= 0.
+func NewPool(workers int, readRate float64) *Pool {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	p := &Pool{
+		workers: workers,
+		tokens:  make(chan struct{}, workers),
+	}
+	if readRate > 0 {
+		// Bursts of one block, or a second's worth if less, keep the readers from waiting on every small read.
+		burst := blockSize
+		if readRate < blockSize {
+			burst = int(readRate)
+		}
+		p.limiter = iox.NewLimiter(nil, readRate, burst)
+	}
+	return p
+}
+
+// acquire blocks until a hasher may run, or ctx is done.
+func (p *Pool) acquire(ctx context.Context) error {
+	select {
+	case p.tokens <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) release() {
+	<-p.tokens
+}
@@ -0,0 +1,95 @@
+package maketorrent
+
+import (
+	"crypto/sha1"
+	"strings"
+	"time"
+
+	"github.com/takeyourhatoff/bt/bencode"
+)
+
+// reuse returns the hashes of the pieces of info which can be taken from prev, with nil for those which must be
+// hashed. A piece is reused when it lines up with a piece of prev, and every byte of it comes from the same offset
+// of a file with the same path and length in prev which was last modified before since, or from padding which
+// follows such a file in both. mtimes holds the modification times of the files of info, keyed by their slash
+// separated paths.
+func reuse(info *bencode.InfoDict, mtimes map[string]time.Time, prev *bencode.InfoDict, since time.Time) [][]byte {
+	n := numPieces(info)
+	known := make([][]byte, n)
+	if prev.PieceLength != info.PieceLength {
+		return known
+	}
+	files, prevFiles := fileList(info), fileList(prev)
+	prevIndex := make(map[string]int, len(prevFiles))
+	prevOffsets := make([]int64, len(prevFiles))
+	var prevTotal int64
+	for m, f := range prevFiles {
+		prevIndex[strings.Join(f.Path, "/")] = m
+		prevOffsets[m] = prevTotal
+		prevTotal += f.Length
+	}
+
+	// at holds the offset in prev of each unchanged file, or -1, and match the index of the file in prev.
+	at := make([]int64, len(files))
+	match := make([]int, len(files))
+	for n, f := range files {
+		at[n] = -1
+		if f.IsPadding() {
+			if n > 0 && at[n-1] >= 0 {
+				m := match[n-1] + 1
+				if m < len(prevFiles) && prevFiles[m].IsPadding() && prevFiles[m].Length == f.Length {
+					at[n], match[n] = prevOffsets[m], m
+				}
+			}
+			continue
+		}
+		key := strings.Join(f.Path, "/")
+		m, ok := prevIndex[key]
+		if !ok || prevFiles[m].Length != f.Length || prevFiles[m].IsPadding() || !mtimes[key].Before(since) {
+			continue
+		}
+		at[n], match[n] = prevOffsets[m], m
+	}
+
+	total := info.TotalLength()
+	var file int
+	var offset int64 // of files[file]
+	for i := 0; i < n; i++ {
+		start, length := pieceBounds(i, info.PieceLength, total)
+		end := start + length
+		for file < len(files) && offset+files[file].Length <= start {
+			offset += files[file].Length
+			file++
+		}
+		// Every non-empty file in the piece must be at the same offset relative to the piece in prev.
+		ok, first := true, true
+		var delta int64
+		for f, off := file, offset; ok && f < len(files) && off < end; off, f = off+files[f].Length, f+1 {
+			if files[f].Length == 0 {
+				continue
+			}
+			d := at[f] - off
+			ok = at[f] >= 0 && (first || d == delta)
+			delta, first = d, false
+		}
+		if !ok || (start+delta)%info.PieceLength != 0 {
+			continue
+		}
+		if length < info.PieceLength && end+delta != prevTotal {
+			continue
+		}
+		j := int((start + delta) / info.PieceLength)
+		if j < prev.NumPieces() {
+			known[i] = prev.RawPieces[j*sha1.Size : (j+1)*sha1.Size]
+		}
+	}
+	return known
+}
+
+// fileList returns the files of info, or a single file named after the torrent if it has only one.
+func fileList(info *bencode.InfoDict) []bencode.File {
+	if len(info.Files) == 0 {
+		return []bencode.File{{Length: info.Length, Path: []string{info.Name}}}
+	}
+	return info.Files
+}
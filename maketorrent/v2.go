@@ -0,0 +1,139 @@
+package maketorrent
+
+import (
+	"bytes"
+	"crypto/sha256"
+
+	"github.com/takeyourhatoff/bt/bencode"
+)
+
+// Format is the version of a torrent.
+type Format int
+
+const (
+	V1     Format = iota // the original format, hashed with SHA-1
+	V2                   // BEP 52, hashed with per-file SHA-256 merkle trees
+	Hybrid               // both V1 and V2, in a form readable by clients which understand either
+)
+
+// leafSize is the size of the blocks which are the leaves of BEP 52 merkle trees.
+const leafSize = 16 << 10
+
+// v2Piece describes the part of a piece which belongs to a file, in a torrent whose files start on piece
+// boundaries.
+type v2Piece struct {
+	data  int64 // the length of the file's data in the piece, which is followed by padding
+	whole bool  // whether the piece holds the whole file
+}
+
+// v2Layout returns the v2Piece of each piece of info, whose files start on piece boundaries.
+func v2Layout(info *bencode.InfoDict) []v2Piece {
+	layout := make([]v2Piece, numPieces(info))
+	var offset int64
+	for _, f := range fileList(info) {
+		if !f.IsPadding() {
+			first := int(offset / info.PieceLength)
+			for n := int64(0); n*info.PieceLength < f.Length; n++ {
+				data := f.Length - n*info.PieceLength
+				if data > info.PieceLength {
+					data = info.PieceLength
+				}
+				layout[first+int(n)] = v2Piece{data, f.Length <= info.PieceLength}
+			}
+		}
+		offset += f.Length
+	}
+	return layout
+}
+
+// hash returns the merkle hash of the piece in buf. That of a piece which holds a whole file is its pieces root,
+// and otherwise it is the piece's entry in the file's piece layer.
+func (p v2Piece) hash(buf []byte, pieceLength int64) []byte {
+	var leaves [][]byte
+	for off := int64(0); off < p.data; off += leafSize {
+		end := off + leafSize
+		if end > p.data {
+			end = p.data
+		}
+		h := sha256.Sum256(buf[off:end])
+		leaves = append(leaves, h[:])
+	}
+	width := int(pieceLength / leafSize)
+	if p.whole {
+		width = nextPow2(len(leaves))
+	}
+	return merkleRoot(leaves, width, make([]byte, sha256.Size))
+}
+
+// fileTree returns the BEP 52 file tree and piece layers of info, whose files start on piece boundaries, given the
+// merkle hash of each of its pieces.
+func fileTree(info *bencode.InfoDict, hashes [][]byte) (bencode.FileTree, map[string][]byte) {
+	tree := make(bencode.FileTree)
+	layers := make(map[string][]byte)
+	// The hash of a piece beyond the end of a file, whose leaves are all zero.
+	pad := merkleRoot(nil, int(info.PieceLength/leafSize), make([]byte, sha256.Size))
+	var offset int64
+	for _, f := range fileList(info) {
+		if f.IsPadding() {
+			offset += f.Length
+			continue
+		}
+		v := bencode.V2File{Attr: f.Attr, Length: f.Length, SymlinkPath: f.SymlinkPath}
+		if f.Length > 0 {
+			first := int(offset / info.PieceLength)
+			n := int((f.Length + info.PieceLength - 1) / info.PieceLength)
+			if n == 1 {
+				v.PiecesRoot = hashes[first]
+			} else {
+				layer := hashes[first : first+n]
+				v.PiecesRoot = merkleRoot(layer, nextPow2(n), pad)
+				layers[string(v.PiecesRoot)] = bytes.Join(layer, nil)
+			}
+		}
+		node := tree
+		for _, name := range f.Path[:len(f.Path)-1] {
+			child, ok := node[name].(bencode.FileTree)
+			if !ok {
+				child = make(bencode.FileTree)
+				node[name] = child
+			}
+			node = child
+		}
+		node[f.Path[len(f.Path)-1]] = bencode.FileTree{"": v}
+		offset += f.Length
+	}
+	return tree, layers
+}
+
+// merkleRoot returns the root of the binary SHA-256 merkle tree whose leaves are hashes followed by copies of pad,
+// up to width leaves, which must be a power of two.
+func merkleRoot(hashes [][]byte, width int, pad []byte) []byte {
+	layer := make([][]byte, width)
+	for i := range layer {
+		if i < len(hashes) {
+			layer[i] = hashes[i]
+		} else {
+			layer[i] = pad
+		}
+	}
+	h := sha256.New()
+	for len(layer) > 1 {
+		for i := 0; i < len(layer)/2; i++ {
+			h.Reset()
+			h.Write(layer[2*i])
+			h.Write(layer[2*i+1])
+			layer[i] = h.Sum(nil)
+		}
+		layer = layer[:len(layer)/2]
+	}
+	return layer[0]
+}
+
+// nextPow2 returns the smallest power of two which is at least n, and at least 1.
+func nextPow2(n int) int {
+	p := 1
+	for p < n {
+		p *= 2
+	}
+	return p
+}